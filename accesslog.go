@@ -0,0 +1,124 @@
+package servicegroup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the line format of the access log written when EnableAccessLog is set.
+type AccessLogFormat string
+
+// Access log formats.
+const (
+	AccessLogCommon   AccessLogFormat = "common"   // NCSA Common Log Format
+	AccessLogCombined AccessLogFormat = "combined" // Common Log Format plus the referer and user agent
+	AccessLogJSON     AccessLogFormat = "json"     // the AccessLogEntry as a JSON object, duration included
+)
+
+// AccessLogEntry describes one completed service request.
+type AccessLogEntry struct {
+	Time             time.Time     `json:"time"` // when the request started
+	RemoteAddr       string        `json:"remote_addr"`
+	Method           string        `json:"method"`
+	URI              string        `json:"uri"`
+	Proto            string        `json:"proto"`
+	Status           int           `json:"status"`
+	BytesIn          int64         `json:"bytes_in"`  // request body bytes read by the handler
+	BytesOut         int64         `json:"bytes_out"` // response body bytes written
+	Duration         time.Duration `json:"duration_ns"`
+	Referer          string        `json:"referer,omitempty"`
+	UserAgent        string        `json:"user_agent,omitempty"`
+	ClientDisconnect bool          `json:"client_disconnect,omitempty"` // the client went away before the response finished
+}
+
+// format formats the entry as an access log line.
+func (e AccessLogEntry) format(format AccessLogFormat) string {
+	if format == AccessLogJSON {
+		line, _ := json.Marshal(e)
+		return string(line)
+	}
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+	size := "-"
+	if e.BytesOut > 0 {
+		size = fmt.Sprint(e.BytesOut)
+	}
+	line := fmt.Sprintf("%s - - [%s] %q %d %s", host, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Proto, e.Status, size)
+	if format == AccessLogCombined {
+		line += fmt.Sprintf(" %q %q", e.Referer, e.UserAgent)
+	}
+	if e.ClientDisconnect {
+		line += " client_disconnect"
+	}
+	return line
+}
+
+// logAccess writes entry to the access log.
+func (g *Group) logAccess(entry AccessLogEntry) {
+	format := g.AccessLogFormat
+	if format == "" {
+		format = AccessLogCommon
+	}
+	g.logEvent("http.request", []slog.Attr{
+		slog.String("remote_addr", entry.RemoteAddr),
+		slog.String("method", entry.Method),
+		slog.String("uri", entry.URI),
+		slog.String("proto", entry.Proto),
+		slog.Int("status", entry.Status),
+		slog.Int64("bytes_in", entry.BytesIn),
+		slog.Int64("bytes_out", entry.BytesOut),
+		slog.Duration("duration", entry.Duration),
+		slog.String("referer", entry.Referer),
+		slog.String("user_agent", entry.UserAgent),
+		slog.Bool("client_disconnect", entry.ClientDisconnect),
+	}, "%s", entry.format(format))
+}
+
+// accessLogBuffer is a fixed-size ring buffer of the most recent access log entries.
+type accessLogBuffer struct {
+	mu      sync.Mutex
+	entries []AccessLogEntry
+	next    int  // index the next entry is written to
+	full    bool // whether entries has wrapped around
+}
+
+func newAccessLogBuffer(size int) *accessLogBuffer {
+	return &accessLogBuffer{entries: make([]AccessLogEntry, size)}
+}
+
+func (b *accessLogBuffer) add(entry AccessLogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// recent returns the buffered entries, oldest first.
+func (b *accessLogBuffer) recent() []AccessLogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		return append([]AccessLogEntry(nil), b.entries[:b.next]...)
+	}
+	return append(append([]AccessLogEntry(nil), b.entries[b.next:]...), b.entries[:b.next]...)
+}
+
+// serveRecentRequests writes the buffered access log entries as JSON, oldest first.
+func (g *Group) serveRecentRequests(w http.ResponseWriter, r *http.Request) {
+	entries := []AccessLogEntry{}
+	if buffer := g.state.recentRequests(); buffer != nil {
+		entries = buffer.recent()
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
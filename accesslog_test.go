@@ -0,0 +1,96 @@
+package servicegroup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRecentRequests_KeepsLastN(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	group.AccessLogBufferSize = 2
+	handler := group.serviceHandler()
+	for _, path := range []string{"/one", "/two", "/three"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/requests", nil))
+	Equals(t, http.StatusOK, rec.Code)
+	var entries []AccessLogEntry
+	Ok(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	Equals(t, 2, len(entries), "buffer must hold only the most recent requests")
+	Equals(t, "/two", entries[0].URI)
+	Equals(t, "/three", entries[1].URI)
+	Equals(t, http.StatusTeapot, entries[1].Status)
+}
+
+func TestServiceHandler_AccessLog(t *testing.T) {
+	logger := &recordingLogger{}
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, "short and stout")
+	}))
+	group.Logger = logger
+	group.EnableAccessLog = true
+	handler := group.serviceHandler()
+
+	req := httptest.NewRequest("GET", "/brew?pot=1", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("User-Agent", "kettle/1.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	Equals(t, 1, len(logger.lines))
+	line := logger.lines[0]
+	Assert(t, strings.HasPrefix(line, "10.0.0.1 - - ["), "common log line must start with the client host, got %q", line)
+	Assert(t, strings.HasSuffix(line, `] "GET /brew?pot=1 HTTP/1.1" 418 15`), "common log line must carry the request, status and size, got %q", line)
+
+	group.AccessLogFormat = AccessLogCombined
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	Assert(t, strings.HasSuffix(logger.lines[1], `418 15 "" "kettle/1.0"`), "combined log line must add referer and user agent, got %q", logger.lines[1])
+
+	group.AccessLogFormat = AccessLogJSON
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	var entry AccessLogEntry
+	Ok(t, json.Unmarshal([]byte(logger.lines[2]), &entry))
+	Equals(t, http.StatusTeapot, entry.Status)
+	Equals(t, "/brew?pot=1", entry.URI)
+	Assert(t, entry.Duration > 0, "JSON log line must carry the duration")
+}
+
+func TestServiceHandler_SuppressClientDisconnectLogs(t *testing.T) {
+	logger := &recordingLogger{}
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "too late")
+	}))
+	group.Logger = logger
+	group.EnableAccessLog = true
+	handler := group.serviceHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // the client hung up
+	disconnected := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+
+	handler.ServeHTTP(httptest.NewRecorder(), disconnected)
+	Equals(t, 1, len(logger.lines))
+	Assert(t, strings.HasSuffix(logger.lines[0], " client_disconnect"), "disconnects must be tagged by default, got %q", logger.lines[0])
+
+	group.SuppressClientDisconnectLogs = true
+	handler = group.serviceHandler()
+	handler.ServeHTTP(httptest.NewRecorder(), disconnected)
+	Equals(t, 1, len(logger.lines), "disconnected requests' lines must be suppressed")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	Equals(t, 2, len(logger.lines), "other requests must still be logged")
+
+	errorLog := group.errorLog()
+	errorLog.Printf("http: response write: write tcp 10.0.0.1:8080->10.0.0.2:5555: %s", syscall.EPIPE)
+	errorLog.Printf("http: TLS handshake error from 10.0.0.2:5555: read: %s", syscall.ECONNRESET)
+	Equals(t, 2, len(logger.lines), "ErrorLog lines about disconnects must be suppressed")
+	errorLog.Printf("http: superfluous response.WriteHeader call")
+	Equals(t, 3, len(logger.lines), "other ErrorLog lines must still be logged")
+}
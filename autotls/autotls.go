@@ -0,0 +1,58 @@
+// Package autotls gets a servicegroup's service server certificates from Let's Encrypt (or another ACME directory)
+// using golang.org/x/crypto/acme/autocert. It's a module of its own, so only users who want automatic TLS pull in
+// autocert:
+//
+//	tlsConfig, challenges := autotls.New(autotls.Config{Hosts: []string{"example.com"}, CacheDir: "/var/cache/certs"})
+//	group := servicegroup.NewGroup(mux)
+//	group.TLSConfig = tlsConfig
+//	group.AddServer(":80", challenges)
+package autotls
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config configures New.
+type Config struct {
+	// Hosts are the hosts certificates are obtained and renewed for; handshakes for any other host fail.
+	Hosts []string
+
+	// CacheDir, when set, is the directory certificates are cached in. Without it they're requested afresh on every
+	// start, which soon runs into the CA's rate limits (default "", no cache).
+	CacheDir string
+
+	// DirectoryURL is the ACME directory certificates are requested from, eg Let's Encrypt's staging one (default
+	// "", Let's Encrypt's production directory).
+	DirectoryURL string
+
+	// TLSConfig, when set, is the TLS config to start from; it's kept bar its certificate selection (default nil).
+	TLSConfig *tls.Config
+}
+
+// New returns the TLS config to set as the Group's TLSConfig, getting certificates from an autocert.Manager that
+// accepts the CA's terms of service, and the handler answering the ACME HTTP-01 challenges, redirecting other
+// requests to HTTPS, to serve on port 80, eg with the Group's AddServer.
+func New(config Config) (*tls.Config, http.Handler) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.Hosts...),
+	}
+	if config.CacheDir != "" {
+		manager.Cache = autocert.DirCache(config.CacheDir)
+	}
+	if config.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: config.DirectoryURL}
+	}
+
+	tlsConfig := manager.TLSConfig()
+	if config.TLSConfig != nil {
+		tlsConfig = config.TLSConfig.Clone()
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	}
+	return tlsConfig, manager.HTTPHandler(nil)
+}
@@ -0,0 +1,82 @@
+package autotls
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNew_GetsCertificatesFromTheDirectory(t *testing.T) {
+	// * Serve TLS with New's config pointed at a fake ACME directory
+	// * Validate a handshake for a listed host asks the directory for a certificate, and one for another host doesn't
+	var directoryHits int32
+	directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&directoryHits, 1)
+		http.Error(w, "fake ACME directory", http.StatusNotFound)
+	}))
+	defer directory.Close()
+
+	config, _ := New(Config{Hosts: []string{"example.com"}, DirectoryURL: directory.URL})
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}(conn)
+		}
+	}()
+
+	handshake := func(serverName string) error {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if handshake("other.com") == nil {
+		t.Error("handshake for an unlisted host must fail")
+	}
+	if hits := atomic.LoadInt32(&directoryHits); hits != 0 {
+		t.Errorf("unlisted hosts must not reach the ACME directory, got %d requests", hits)
+	}
+	if handshake("example.com") == nil {
+		t.Error("handshake must fail while the ACME directory is down")
+	}
+	if atomic.LoadInt32(&directoryHits) == 0 {
+		t.Error("listed hosts must be requested from the ACME directory")
+	}
+}
+
+func TestNew_KeepsTheGivenTLSConfig(t *testing.T) {
+	config, _ := New(Config{Hosts: []string{"example.com"}, TLSConfig: &tls.Config{MinVersion: tls.VersionTLS13}})
+	if config.GetCertificate == nil {
+		t.Error("TLS config must get certificates from autocert")
+	}
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("the given TLS config must be kept, got MinVersion %x", config.MinVersion)
+	}
+}
+
+func TestNew_ChallengeHandlerRedirectsToHTTPS(t *testing.T) {
+	_, challenges := New(Config{Hosts: []string{"example.com"}})
+	rec := httptest.NewRecorder()
+	challenges.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/ping", nil))
+	if rec.Code != http.StatusFound {
+		t.Errorf("expected %d, got %d", http.StatusFound, rec.Code)
+	}
+	if location := rec.Header().Get("Location"); location != "https://example.com/ping" {
+		t.Errorf("expected a redirect to https://example.com/ping, got %q", location)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/localytics/servicegroup/autotls
+
+go 1.21
+
+require golang.org/x/crypto v0.23.0
+
+require (
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+)
@@ -6,5 +6,6 @@ set -ex
 
 # Run tests, with race detector on.
 CGO_ENABLED=1 go test -v -race -coverprofile=coverage.txt -covermode=atomic -count=1 ./...
+(cd autotls && CGO_ENABLED=1 go test -v -race -count=1 ./...)
 
 internal/tools/bin/golangci-lint run
@@ -0,0 +1,120 @@
+package servicegroup
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connTracker follows a server's connections through their http.ConnState transitions.
+type connTracker struct {
+	active int64 // number of open connections, for lock-free reads; accessed atomically
+
+	mu     sync.Mutex
+	addr   string                 // address of the listener the connections are accepted on, once it's bound
+	opened map[net.Conn]time.Time // open connections and when they were accepted
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{opened: make(map[net.Conn]time.Time)}
+}
+
+// track records a connection's state transition, returning how long the connection was open once it's closed or
+// hijacked.
+func (t *connTracker) track(conn net.Conn, state http.ConnState) (lifetime time.Duration, done bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		t.opened[conn] = time.Now()
+		atomic.AddInt64(&t.active, 1)
+	case http.StateHijacked, http.StateClosed:
+		if opened, ok := t.opened[conn]; ok {
+			delete(t.opened, conn)
+			atomic.AddInt64(&t.active, -1)
+			return time.Since(opened), true
+		}
+	}
+	return 0, false
+}
+
+// open returns the number of connections currently open.
+func (t *connTracker) open() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.opened)
+}
+
+// listening records the address of the listener the tracked connections are accepted on.
+func (t *connTracker) listening(addr string) {
+	t.mu.Lock()
+	t.addr = addr
+	t.mu.Unlock()
+}
+
+// gauge returns the listener's address and how many connections are open on it; addr is empty until the listener
+// is bound.
+func (t *connTracker) gauge() (addr string, active int64) {
+	t.mu.Lock()
+	addr = t.addr
+	t.mu.Unlock()
+	return addr, atomic.LoadInt64(&t.active)
+}
+
+// ActiveConnections returns the number of connections currently open (including idle keep-alive connections, and
+// not counting hijacked ones) on each of the Group's bound listeners, those of servers added with AddServer
+// included, keyed by listener address; a live gauge for connection-based autoscaling.
+func (g *Group) ActiveConnections() map[string]int64 {
+	state := g.st()
+	state.mu.Lock()
+	trackers := append([]*connTracker{state.conns, state.debugConns}, state.addedConns...)
+	state.mu.Unlock()
+	counts := make(map[string]int64, len(trackers))
+	for _, t := range trackers {
+		if addr, active := t.gauge(); addr != "" {
+			counts[addr] = active
+		}
+	}
+	return counts
+}
+
+// trackConn is the service server's http.Server.ConnState callback; it passes each transition on to
+// ServiceConnState once the Group has tracked it.
+func (g *Group) trackConn(conn net.Conn, state http.ConnState) {
+	lifetime, done := g.state.conns.track(conn, state)
+	if done && state == http.StateClosed && g.OnConnClose != nil {
+		g.OnConnClose(conn.RemoteAddr().String(), lifetime)
+	}
+	if state == http.StateNew || done {
+		g.activeConnsChanged(g.state.conns)
+	}
+	if g.ServiceConnState != nil {
+		g.ServiceConnState(conn, state)
+	}
+}
+
+// trackDebugConn is the debug server's http.Server.ConnState callback.
+func (g *Group) trackDebugConn(conn net.Conn, state http.ConnState) {
+	if _, done := g.state.debugConns.track(conn, state); state == http.StateNew || done {
+		g.activeConnsChanged(g.state.debugConns)
+	}
+}
+
+// trackAddedConn returns the http.Server.ConnState callback of a server added with AddServer, tracking its
+// connections in conns.
+func (g *Group) trackAddedConn(conns *connTracker) func(net.Conn, http.ConnState) {
+	return func(conn net.Conn, state http.ConnState) {
+		if _, done := conns.track(conn, state); state == http.StateNew || done {
+			g.activeConnsChanged(conns)
+		}
+	}
+}
+
+// activeConnsChanged reports t's connection count to OnActiveConnsChange.
+func (g *Group) activeConnsChanged(t *connTracker) {
+	if g.OnActiveConnsChange != nil {
+		g.OnActiveConnsChange(t.gauge())
+	}
+}
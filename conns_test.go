@@ -0,0 +1,141 @@
+package servicegroup
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTrackConn_ReportsClosedConnections(t *testing.T) {
+	group := NewGroup(nil)
+	closed := make(chan time.Duration, 1)
+	group.OnConnClose = func(remoteAddr string, lifetime time.Duration) {
+		Equals(t, "192.0.2.1:1234", remoteAddr)
+		closed <- lifetime
+	}
+
+	conn := fakeConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}}
+	group.trackConn(conn, http.StateNew)
+	group.trackConn(conn, http.StateActive)
+	group.trackConn(conn, http.StateIdle)
+	time.Sleep(10 * time.Millisecond)
+	group.trackConn(conn, http.StateClosed)
+
+	select {
+	case lifetime := <-closed:
+		Assert(t, lifetime >= 10*time.Millisecond, "lifetime %s must cover the connection's life", lifetime)
+	default:
+		Assert(t, false, "OnConnClose must be called when a connection closes")
+	}
+}
+
+func TestActiveConnections_GaugesOpenConnectionsPerListener(t *testing.T) {
+	group := NewGroup(nil)
+	group.state.conns.listening("[::]:8080")
+	var reported []int64
+	group.OnActiveConnsChange = func(listenerAddr string, active int64) {
+		Equals(t, "[::]:8080", listenerAddr)
+		reported = append(reported, active)
+	}
+	Equals(t, map[string]int64{"[::]:8080": 0}, group.ActiveConnections(), "only bound listeners are reported")
+
+	closed := fakeConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}}
+	hijacked := fakeConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2}}
+	group.trackConn(closed, http.StateNew)
+	group.trackConn(hijacked, http.StateNew)
+	group.trackConn(closed, http.StateActive)
+	group.trackConn(hijacked, http.StateActive)
+	Equals(t, map[string]int64{"[::]:8080": 2}, group.ActiveConnections())
+
+	group.trackConn(hijacked, http.StateHijacked)
+	group.trackConn(closed, http.StateIdle)
+	group.trackConn(closed, http.StateClosed)
+	group.trackConn(closed, http.StateClosed) // repeated transitions must not drive the gauge negative
+	Equals(t, map[string]int64{"[::]:8080": 0}, group.ActiveConnections())
+	Equals(t, []int64{1, 2, 1, 0}, reported)
+}
+
+func TestNewWorkgroup_ActiveConnectionsOfAddedServers(t *testing.T) {
+	// * Run a group with an added server and open a connection to it
+	// * Validate ActiveConnections and OnActiveConnsChange report the added server's listener and its open connection
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.AddServer("127.0.0.1:0", http.NotFoundHandler())
+	type gauge struct {
+		addr   string
+		active int64
+	}
+	reported := make(chan gauge, 2)
+	group.OnActiveConnsChange = func(listenerAddr string, active int64) {
+		reported <- gauge{listenerAddr, active}
+	}
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	var added string
+	for addr := range group.ActiveConnections() {
+		if addr != "pipe" {
+			added = addr
+		}
+	}
+	Assert(t, added != "", "the added server's listener must be reported, got %v", group.ActiveConnections())
+
+	conn, err := net.Dial("tcp", added)
+	Ok(t, err)
+	deadline := time.Now().Add(3 * time.Second)
+	for group.ActiveConnections()[added] != 1 {
+		Assert(t, time.Now().Before(deadline), "the added server's connection must be counted, got %v", group.ActiveConnections())
+		time.Sleep(time.Millisecond)
+	}
+	Equals(t, gauge{added, 1}, <-reported, "the added server's connection must be pushed to OnActiveConnsChange")
+	conn.Close()
+	Equals(t, gauge{added, 0}, <-reported, "closing it must be pushed too")
+
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_ServiceConnState(t *testing.T) {
+	// * Run a group with a ServiceConnState hook, make a request, then close the client's connection
+	// * Validate the hook saw the connection through from StateNew to StateClosed
+	listener, client := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	states := make(chan http.ConnState, 10)
+	group.ServiceConnState = func(conn net.Conn, state http.ConnState) {
+		states <- state
+	}
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	resp, err := client.Get("http://servicegroup/")
+	Ok(t, err)
+	resp.Body.Close()
+	client.CloseIdleConnections()
+	var seen []http.ConnState
+	for state := range states {
+		seen = append(seen, state)
+		if state == http.StateClosed {
+			break
+		}
+	}
+	Equals(t, []http.ConnState{http.StateNew, http.StateActive, http.StateIdle, http.StateClosed}, seen)
+
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+// fakeConn is a net.Conn that only knows its remote address.
+type fakeConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c fakeConn) RemoteAddr() net.Addr { return c.remote }
@@ -0,0 +1,20 @@
+package servicegroup
+
+import (
+	"net/http"
+	"time"
+)
+
+// SetWriteDeadline sets the write deadline of the connection serving w, overriding the service server's
+// ServiceWriteTimeout for this response; a zero time clears the deadline. Use it from handlers for long-polling or
+// streaming responses that would otherwise be cut off. The Group's middleware keeps w compatible with
+// http.ResponseController, which this wraps.
+func SetWriteDeadline(w http.ResponseWriter, deadline time.Time) error {
+	return http.NewResponseController(w).SetWriteDeadline(deadline)
+}
+
+// SetReadDeadline sets the read deadline of the connection serving w, eg to allow a slow request body upload; a
+// zero time clears the deadline. Like SetWriteDeadline, it wraps http.ResponseController.
+func SetReadDeadline(w http.ResponseWriter, deadline time.Time) error {
+	return http.NewResponseController(w).SetReadDeadline(deadline)
+}
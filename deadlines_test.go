@@ -0,0 +1,32 @@
+package servicegroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetDeadlines_ThroughServiceMiddleware(t *testing.T) {
+	// * Serve a response that outlives the write timeout from a handler that extends its own deadlines
+	// * Validate the deadlines apply through the service middleware and the full response arrives
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Ok(t, SetReadDeadline(w, time.Time{}))
+		Ok(t, SetWriteDeadline(w, time.Now().Add(time.Second)))
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "streamed")
+	}))
+	server := httptest.NewUnstartedServer(group.serviceHandler())
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	Ok(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Ok(t, err)
+	Equals(t, "streamed", string(body))
+}
@@ -0,0 +1,346 @@
+package servicegroup
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// debugHandler returns the handler for the debug server: the DebugHandler if set (with the pprof endpoints added if
+// it's a *http.ServeMux without them), otherwise the default ServeMux with the pprof endpoints hidden if DisablePprof
+// is set, plus any servicegroup endpoints that are enabled.
+func (g *Group) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	var fallback http.Handler = http.DefaultServeMux
+	if g.DebugHandler != nil {
+		fallback = g.DebugHandler
+		mux.Handle("/", g.DebugHandler)
+		if custom, ok := g.DebugHandler.(*http.ServeMux); ok && !g.DisablePprof && !handles(custom, "/debug/pprof/") {
+			mux.HandleFunc("/debug/pprof/", pprof.Index)
+			mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+			mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+			mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+			mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		}
+	} else {
+		mux.Handle("/", http.DefaultServeMux)
+		if g.DisablePprof {
+			mux.Handle("/debug/pprof/", http.NotFoundHandler())
+		}
+	}
+	if !g.DisableReadyz {
+		mux.HandleFunc("/readyz", g.serveReadyz)
+	}
+	mux.Handle("/healthz", g.healthzHandler(fallback))
+	if g.EnableStateEndpoint {
+		mux.HandleFunc("/debug/servicegroup", g.serveState)
+	}
+	if g.EnableConfigEndpoint {
+		mux.HandleFunc("/debug/config", g.serveConfig)
+	}
+	if g.AccessLogBufferSize > 0 {
+		mux.HandleFunc("/debug/requests", g.serveRecentRequests)
+	}
+	if g.EnableMetrics {
+		mux.HandleFunc("/metrics", g.serveMetrics)
+	}
+	if g.EnablePreStopEndpoint {
+		mux.HandleFunc("/debug/prestop", g.servePreStop)
+	}
+	if auth := g.debugBasicAuth(); auth != nil {
+		// only the /debug/ subtree needs credentials, so probes without them still get through
+		protected := http.NewServeMux()
+		protected.Handle("/debug/", requireBasicAuth(*auth, mux))
+		protected.Handle("/", mux)
+		return protected
+	}
+	return mux
+}
+
+// BasicAuth holds HTTP Basic Auth credentials.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// requireBasicAuth responds 401 Unauthorized to requests that don't carry the expected Basic Auth credentials.
+// Credentials are compared in constant time.
+func requireBasicAuth(auth BasicAuth, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(auth.Username)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(auth.Password)) == 1
+		if !usernameOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="debug", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handles reports whether mux has a handler registered for path or a subtree under it, rather than falling back to a
+// 404 or a catch-all pattern like "/".
+func handles(mux *http.ServeMux, path string) bool {
+	_, pattern := mux.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: path}})
+	if i := strings.Index(pattern, " "); i >= 0 {
+		pattern = pattern[i+1:] // drop a method, as in "GET /debug/pprof/"
+	}
+	return strings.HasPrefix(pattern, path)
+}
+
+// debugBasicAuth returns the credentials the debug server requires: DebugBasicAuth, or DebugUsername and
+// DebugPassword if both are set, or nil if neither is configured.
+func (g *Group) debugBasicAuth() *BasicAuth {
+	if g.DebugBasicAuth != nil {
+		return g.DebugBasicAuth
+	}
+	if g.DebugUsername != "" && g.DebugPassword != "" {
+		return &BasicAuth{Username: g.DebugUsername, Password: g.DebugPassword}
+	}
+	return nil
+}
+
+// groupConfig is the JSON representation of a Group's effective configuration.
+type groupConfig struct {
+	ServiceServerAddr            string          `json:"service_server_addr"`
+	ServiceNetwork               string          `json:"service_network"`
+	DebugServerAddr              string          `json:"debug_server_addr"`
+	DebugNetwork                 string          `json:"debug_network"`
+	ShutdownTimeout              string          `json:"shutdown_timeout"`
+	DebugShutdownTimeout         string          `json:"debug_shutdown_timeout"`
+	ServiceReadHeaderTimeout     string          `json:"service_read_header_timeout"`
+	ServiceReadTimeout           string          `json:"service_read_timeout"`
+	ServiceWriteTimeout          string          `json:"service_write_timeout"`
+	ServiceIdleTimeout           string          `json:"service_idle_timeout"`
+	ServiceMaxHeaderBytes        int             `json:"service_max_header_bytes"`
+	RequestTimeout               string          `json:"request_timeout"`
+	DisablePprof                 bool            `json:"disable_pprof"`
+	DisableDebugServer           bool            `json:"disable_debug_server"`
+	DisableSignalWatcher         bool            `json:"disable_signal_watcher"`
+	DisableKeepAlives            bool            `json:"disable_keep_alives"`
+	RecoverPanics                bool            `json:"recover_panics"`
+	DisableReadyz                bool            `json:"disable_readyz"`
+	HealthCheckTimeout           string          `json:"health_check_timeout"`
+	DebugHandler                 bool            `json:"debug_handler"`
+	ConfigureServiceServer       bool            `json:"configure_service_server"`
+	ConfigureDebugServer         bool            `json:"configure_debug_server"`
+	ShutdownJitter               string          `json:"shutdown_jitter"`
+	ShutdownOrder                ShutdownOrder   `json:"shutdown_order"`
+	SoftDrainTimeout             string          `json:"soft_drain_timeout"`
+	HardDrainTimeout             string          `json:"hard_drain_timeout"`
+	PreShutdownDelay             string          `json:"pre_shutdown_delay"`
+	PostDrainHold                string          `json:"post_drain_hold"`
+	MaxRequests                  int64           `json:"max_requests"`
+	MaxConcurrentRequests        int             `json:"max_concurrent_requests"`
+	GlobalBodyBudget             int64           `json:"global_body_budget"`
+	TLS                          bool            `json:"tls"` // cert and key paths are left out, they reveal where secrets are mounted
+	DebugTLS                     bool            `json:"debug_tls"`
+	EnableH2C                    bool            `json:"enable_h2c"`
+	MaxURILength                 int             `json:"max_uri_length"`
+	MaxHeaderCount               int             `json:"max_header_count"`
+	EnableAccessLog              bool            `json:"enable_access_log"`
+	AccessLogFormat              AccessLogFormat `json:"access_log_format"`
+	SuppressClientDisconnectLogs bool            `json:"suppress_client_disconnect_logs"`
+	AccessLogBufferSize          int             `json:"access_log_buffer_size"`
+	EnableMetrics                bool            `json:"enable_metrics"`
+	ReadyFilePath                string          `json:"ready_file_path"`
+	ShutdownOnParentDeath        bool            `json:"shutdown_on_parent_death"`
+	DebugBasicAuth               bool            `json:"debug_basic_auth"`
+	EnableStateEndpoint          bool            `json:"enable_state_endpoint"`
+	EnableConfigEndpoint         bool            `json:"enable_config_endpoint"`
+	EnablePreStopEndpoint        bool            `json:"enable_pre_stop_endpoint"`
+	PreStopDelay                 string          `json:"pre_stop_delay"`
+	HandlerReloader              bool            `json:"handler_reloader"`
+	ShutdownSignals              []string        `json:"shutdown_signals"`
+	SignalIsCleanExit            bool            `json:"signal_is_clean_exit"`
+	ForceOnSecondSignal          bool            `json:"force_on_second_signal"`
+	EnableProxyProtocol          bool            `json:"enable_proxy_protocol"`
+	DiagnosticSignals            []string        `json:"diagnostic_signals"`
+	TrustLeftmostForwardedFor    bool            `json:"trust_leftmost_forwarded_for"`
+	// Only the names of configured headers are reported; required header values are typically shared secrets.
+	RequiredHeaders          []string `json:"required_headers"`
+	RequiredHeadersSkipPaths []string `json:"required_headers_skip_paths"`
+	TrustedProxies           []string `json:"trusted_proxies"`
+	ResponseHeaders          []string `json:"response_headers"`
+	MaintenanceExemptPaths   []string `json:"maintenance_exempt_paths"`
+}
+
+// config reports the Group's effective configuration.
+func (g *Group) config() groupConfig {
+	return groupConfig{
+		ServiceServerAddr:            g.ServiceServerAddr,
+		ServiceNetwork:               g.ServiceNetwork,
+		DebugServerAddr:              g.DebugServerAddr,
+		DebugNetwork:                 g.DebugNetwork,
+		ShutdownTimeout:              g.ShutdownTimeout.String(),
+		DebugShutdownTimeout:         g.DebugShutdownTimeout.String(),
+		ServiceReadHeaderTimeout:     g.ServiceReadHeaderTimeout.String(),
+		ServiceReadTimeout:           g.ServiceReadTimeout.String(),
+		ServiceWriteTimeout:          g.ServiceWriteTimeout.String(),
+		ServiceIdleTimeout:           g.ServiceIdleTimeout.String(),
+		ServiceMaxHeaderBytes:        g.ServiceMaxHeaderBytes,
+		RequestTimeout:               g.RequestTimeout.String(),
+		DisablePprof:                 g.DisablePprof,
+		DisableDebugServer:           g.DisableDebugServer,
+		DisableSignalWatcher:         g.DisableSignalWatcher,
+		DisableKeepAlives:            g.DisableKeepAlives,
+		RecoverPanics:                g.RecoverPanics,
+		DisableReadyz:                g.DisableReadyz,
+		HealthCheckTimeout:           g.HealthCheckTimeout.String(),
+		DebugHandler:                 g.DebugHandler != nil,
+		ConfigureServiceServer:       g.ConfigureServiceServer != nil,
+		ConfigureDebugServer:         g.ConfigureDebugServer != nil,
+		ShutdownJitter:               g.ShutdownJitter.String(),
+		ShutdownOrder:                g.ShutdownOrder,
+		SoftDrainTimeout:             g.SoftDrainTimeout.String(),
+		HardDrainTimeout:             g.HardDrainTimeout.String(),
+		PreShutdownDelay:             g.PreShutdownDelay.String(),
+		PostDrainHold:                g.PostDrainHold.String(),
+		MaxRequests:                  g.MaxRequests,
+		MaxConcurrentRequests:        g.MaxConcurrentRequests,
+		GlobalBodyBudget:             g.GlobalBodyBudget,
+		TLS:                          g.servesTLS(),
+		DebugTLS:                     g.DebugTLSConfig != nil || g.DebugCertFile != "",
+		EnableH2C:                    g.EnableH2C,
+		MaxURILength:                 g.MaxURILength,
+		MaxHeaderCount:               g.MaxHeaderCount,
+		EnableAccessLog:              g.EnableAccessLog,
+		AccessLogFormat:              g.AccessLogFormat,
+		SuppressClientDisconnectLogs: g.SuppressClientDisconnectLogs,
+		AccessLogBufferSize:          g.AccessLogBufferSize,
+		EnableMetrics:                g.EnableMetrics,
+		ReadyFilePath:                g.ReadyFilePath,
+		ShutdownOnParentDeath:        g.ShutdownOnParentDeath,
+		DebugBasicAuth:               g.debugBasicAuth() != nil,
+		EnableStateEndpoint:          g.EnableStateEndpoint,
+		EnableConfigEndpoint:         g.EnableConfigEndpoint,
+		EnablePreStopEndpoint:        g.EnablePreStopEndpoint,
+		PreStopDelay:                 g.PreStopDelay.String(),
+		HandlerReloader:              g.HandlerReloader != nil,
+		ShutdownSignals:              signalNames(g.ShutdownSignals),
+		SignalIsCleanExit:            g.SignalIsCleanExit,
+		ForceOnSecondSignal:          g.ForceOnSecondSignal,
+		EnableProxyProtocol:          g.EnableProxyProtocol,
+		DiagnosticSignals:            signalNames(g.DiagnosticSignals),
+		RequiredHeaders:              headerNames(g.RequiredHeaders),
+		RequiredHeadersSkipPaths:     g.RequiredHeadersSkipPaths,
+		TrustedProxies:               networkNames(g.TrustedProxies),
+		TrustLeftmostForwardedFor:    g.TrustLeftmostForwardedFor,
+		ResponseHeaders:              headerNames(g.ResponseHeaders),
+		MaintenanceExemptPaths:       g.MaintenanceExemptPaths,
+	}
+}
+
+// headerNames returns the sorted names of a header map, leaving out the values.
+func headerNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// networkNames returns networks in CIDR notation.
+func networkNames(networks []net.IPNet) []string {
+	names := make([]string, len(networks))
+	for i := range networks {
+		names[i] = networks[i].String()
+	}
+	return names
+}
+
+// serveConfig writes the Group's effective configuration as JSON.
+func (g *Group) serveConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, g.config())
+}
+
+// servePreStop marks the Group not ready, then blocks for PreStopDelay, else PreShutdownDelay (or until the caller
+// gives up) before responding, without shutting anything down.
+func (g *Group) servePreStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	atomic.StoreInt32(&g.state.unready, 1)
+	delay := g.PreStopDelay
+	if delay == 0 {
+		delay = g.PreShutdownDelay
+	}
+	g.logf("preStop hook called; reporting not ready and waiting %s for deregistration", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// readyForTraffic reports whether the Group is running and hasn't been marked not ready or started shutting down.
+func (g *Group) readyForTraffic() bool {
+	return g.state.currentPhase() == phaseRunning &&
+		atomic.LoadInt32(&g.state.unready) == 0 && atomic.LoadInt32(&g.state.shuttingDown) == 0
+}
+
+// serveReadyz responds 200 while the Group is ready for traffic and 503 once it isn't, eg because shutdown began.
+func (g *Group) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if !g.readyForTraffic() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// groupStateReport is the JSON body served by the state endpoint.
+type groupStateReport struct {
+	State       string           `json:"state"`
+	Ready       bool             `json:"ready"`
+	StartedAt   time.Time        `json:"started_at"`
+	Uptime      string           `json:"uptime"`
+	BytesIn     int64            `json:"bytes_in"`
+	BytesOut    int64            `json:"bytes_out"`
+	InFlight    int64            `json:"in_flight"`
+	Connections map[string]int64 `json:"connections"`
+	Workers     []string         `json:"workers"`
+	Config      groupConfig      `json:"config"`
+}
+
+// serveState writes the Group's groupStateReport as JSON.
+func (g *Group) serveState(w http.ResponseWriter, r *http.Request) {
+	g.state.mu.Lock()
+	report := groupStateReport{
+		State:     g.state.phase,
+		StartedAt: g.state.started,
+		Config:    g.config(),
+	}
+	g.state.mu.Unlock()
+	report.Workers = g.Workers()
+	report.Ready = g.readyForTraffic()
+	if !report.StartedAt.IsZero() {
+		report.Uptime = time.Since(report.StartedAt).String()
+	}
+	report.BytesIn, report.BytesOut = g.BytesStats()
+	report.InFlight = g.InFlight()
+	report.Connections = g.ActiveConnections()
+	writeJSON(w, http.StatusOK, report)
+}
+
+// writeJSON writes v as an indented JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,251 @@
+package servicegroup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDebugHandler_StateEndpoint(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/servicegroup", nil))
+	Equals(t, http.StatusNotFound, rec.Code, "state endpoint must be opt-in")
+
+	group.EnableStateEndpoint = true
+	rec = httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/servicegroup", nil))
+	Equals(t, http.StatusOK, rec.Code)
+
+	var report groupStateReport
+	Ok(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	Equals(t, phaseIdle, report.State)
+	Equals(t, ":8080", report.Config.ServiceServerAddr)
+	Equals(t, "30s", report.Config.ShutdownTimeout)
+}
+
+func TestDebugHandler_StateEndpointReportsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	group.EnableStateEndpoint = true
+	service, debug := group.serviceHandler(), group.debugHandler()
+	served := make(chan struct{})
+	go func() {
+		service.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(served)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	debug.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/servicegroup", nil))
+	var report groupStateReport
+	Ok(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	Equals(t, int64(1), report.InFlight, "the running request must be reported in flight")
+	close(release)
+	<-served
+
+	rec = httptest.NewRecorder()
+	debug.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/servicegroup", nil))
+	Ok(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	Equals(t, group.InFlight(), report.InFlight)
+	Equals(t, int64(0), report.InFlight, "finished requests must no longer be in flight")
+}
+
+func TestDebugHandler_CustomHandler(t *testing.T) {
+	admin := http.NewServeMux()
+	admin.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "admin")
+	})
+	group := NewGroup(http.NotFoundHandler())
+	group.DebugHandler = admin
+	handler := group.debugHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/admin", nil))
+	Equals(t, "admin", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusOK, rec.Code, "pprof endpoints must be added to a custom ServeMux")
+
+	group.DisablePprof = true
+	rec = httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusNotFound, rec.Code, "pprof endpoints must not be added when disabled")
+
+	group.DisablePprof = false
+	catchAll := http.NewServeMux()
+	catchAll.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "catch-all")
+	})
+	group.DebugHandler = catchAll
+	rec = httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusOK, rec.Code)
+	Assert(t, strings.Contains(rec.Body.String(), "goroutine"), "pprof must be served alongside a catch-all route, got %q", rec.Body)
+
+	group.DebugHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	rec = httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusNotFound, rec.Code, "pprof endpoints must not be added to a custom handler that isn't a ServeMux")
+}
+
+func TestDebugHandler_ConfigEndpoint(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.EnableConfigEndpoint = true
+	group.ShutdownTimeout = 10 * time.Second
+	group.RequiredHeaders = map[string]string{"X-Gateway-Auth": "secret"}
+
+	rec := httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/config", nil))
+	Equals(t, http.StatusOK, rec.Code)
+	Assert(t, !strings.Contains(rec.Body.String(), "secret"), "config must not leak header values: %s", rec.Body)
+
+	var config groupConfig
+	Ok(t, json.Unmarshal(rec.Body.Bytes(), &config))
+	Equals(t, "10s", config.ShutdownTimeout)
+	Equals(t, []string{"X-Gateway-Auth"}, config.RequiredHeaders)
+}
+
+func TestNewWorkgroup_ServesCustomDebugMux(t *testing.T) {
+	// * Run a group with a custom debug mux
+	// * Validate its routes are reachable on the debug port, along with pprof
+	debugMux := http.NewServeMux()
+	debugMux.HandleFunc("/debug/cache", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "cache stats")
+	})
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DebugHandler = debugMux
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	for path, status := range map[string]int{"/debug/cache": http.StatusOK, "/debug/pprof/": http.StatusOK} {
+		resp, err := client.Get("http://127.0.0.1:6060" + path)
+		Ok(t, err)
+		resp.Body.Close()
+		Equals(t, status, resp.StatusCode, path)
+	}
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestDebugHandler_BasicAuth(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.DebugBasicAuth = &BasicAuth{Username: "ops", Password: "hunter2"}
+	group.RegisterHealthCheck("cache", func(ctx context.Context) error { return nil })
+	handler := group.debugHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusUnauthorized, rec.Code, "requests without credentials must be rejected")
+	Assert(t, rec.Header().Get("WWW-Authenticate") != "", "401 must include a WWW-Authenticate challenge")
+
+	req := httptest.NewRequest("GET", "/debug/pprof/", nil)
+	req.SetBasicAuth("ops", "wrong")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	Equals(t, http.StatusUnauthorized, rec.Code, "requests with the wrong password must be rejected")
+
+	req = httptest.NewRequest("GET", "/debug/pprof/", nil)
+	req.SetBasicAuth("ops", "hunter2")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	Equals(t, http.StatusOK, rec.Code, "requests with the right credentials must reach pprof")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	Equals(t, http.StatusOK, rec.Code, "/healthz must not require credentials")
+}
+
+func TestDebugHandler_BasicAuthShorthand(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.DebugUsername = "ops"
+	group.DebugPassword = "hunter2"
+	handler := group.debugHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusUnauthorized, rec.Code, "requests without credentials must be rejected")
+	Assert(t, rec.Header().Get("WWW-Authenticate") != "", "401 must include a WWW-Authenticate challenge")
+
+	req := httptest.NewRequest("GET", "/debug/pprof/", nil)
+	req.SetBasicAuth("ops", "hunter2")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	Equals(t, http.StatusOK, rec.Code, "requests with the right credentials must reach pprof")
+
+	group.DebugPassword = ""
+	rec = httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusOK, rec.Code, "auth must only be required when both username and password are set")
+}
+
+func TestDebugHandler_PreStopEndpoint(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.EnablePreStopEndpoint = true
+	group.PreStopDelay = 50 * time.Millisecond
+	group.state.setPhase(phaseRunning)
+	handler := group.debugHandler()
+	Assert(t, group.readyForTraffic(), "running group must start out ready")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/prestop", nil))
+	Equals(t, http.StatusMethodNotAllowed, rec.Code, "preStop must require POST")
+
+	start := time.Now()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/prestop", nil))
+	Equals(t, http.StatusOK, rec.Code)
+	Assert(t, time.Since(start) >= 50*time.Millisecond, "preStop must block for PreStopDelay")
+	Assert(t, !group.readyForTraffic(), "preStop must mark the group not ready")
+	Equals(t, phaseRunning, group.state.phase, "preStop must not shut the group down")
+
+	group.PreStopDelay = 0
+	group.PreShutdownDelay = 80 * time.Millisecond
+	start = time.Now()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/prestop", nil))
+	Equals(t, http.StatusOK, rec.Code)
+	Assert(t, time.Since(start) >= 80*time.Millisecond, "preStop must block for PreShutdownDelay without a PreStopDelay")
+}
+
+func TestDebugHandler_ReadyzFailsOnceShutdownBegins(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.state.setPhase(phaseRunning)
+	handler := group.debugHandler()
+	get := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		return rec.Code
+	}
+
+	Equals(t, http.StatusOK, get(), "running group must be ready")
+	group.DebugBasicAuth = &BasicAuth{Username: "ops", Password: "hunter2"}
+	handler = group.debugHandler()
+	Equals(t, http.StatusOK, get(), "readyz must not require debug credentials, so orchestrator probes get through")
+	group.beginShutdown()
+	Equals(t, http.StatusServiceUnavailable, get(), "group must stop being ready once shutdown begins")
+
+	group.DisableReadyz = true
+	rec := httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	Equals(t, http.StatusNotFound, rec.Code, "readyz must not be served when disabled")
+}
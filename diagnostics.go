@@ -0,0 +1,48 @@
+package servicegroup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// dumpDiagnostics calls OnDiagnosticSignal, or if it isn't set writes the built-in diagnostics to DiagnosticWriter.
+func (g *Group) dumpDiagnostics() {
+	if g.OnDiagnosticSignal != nil {
+		g.OnDiagnosticSignal()
+		return
+	}
+	w := g.DiagnosticWriter
+	if w == nil {
+		w = os.Stderr
+	}
+	if err := writeDiagnostics(w); err != nil {
+		g.logf("Error writing diagnostics: %s", err)
+	}
+}
+
+// writeDiagnostics writes the memory stats and every goroutine's stack to w.
+func writeDiagnostics(w io.Writer) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	_, err := fmt.Fprintf(w, "=== servicegroup diagnostics at %s ===\n"+
+		"Goroutines: %d\nHeapAlloc: %d\nHeapInuse: %d\nHeapObjects: %d\nSys: %d\nNumGC: %d\nPauseTotal: %s\n\n",
+		time.Now().Format(time.RFC3339), runtime.NumGoroutine(), mem.HeapAlloc, mem.HeapInuse, mem.HeapObjects,
+		mem.Sys, mem.NumGC, time.Duration(mem.PauseTotalNs))
+	if err != nil {
+		return err
+	}
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// signalNames returns the names of sigs.
+func signalNames(sigs []os.Signal) []string {
+	names := make([]string, len(sigs))
+	for i, sig := range sigs {
+		names[i] = sig.String()
+	}
+	return names
+}
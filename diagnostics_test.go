@@ -0,0 +1,45 @@
+package servicegroup
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestDumpDiagnostics_WritesStacksAndMemStats(t *testing.T) {
+	var out bytes.Buffer
+	group := NewGroup(http.NotFoundHandler())
+	group.DiagnosticWriter = &out
+	group.dumpDiagnostics()
+	Assert(t, strings.Contains(out.String(), "HeapAlloc: "), "diagnostics must include memory stats")
+	Assert(t, strings.Contains(out.String(), "TestDumpDiagnostics_WritesStacksAndMemStats"), "diagnostics must include goroutine stacks")
+}
+
+func TestNewWorkgroup_DiagnosticSignalDoesNotShutDown(t *testing.T) {
+	// * Run a group watching SIGUSR1, then send it SIGUSR1
+	// * Validate the hook is called and the group keeps running until it's interrupted
+	listener, _ := NewPipeListener()
+	dumped := make(chan struct{}, 1)
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.DiagnosticSignals = []os.Signal{syscall.SIGUSR1}
+	group.OnDiagnosticSignal = func() { dumped <- struct{}{} }
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	group.Signal(syscall.SIGUSR1)
+	<-dumped
+	select {
+	case err := <-done:
+		Assert(t, false, "diagnostic signal must not stop the group, got: %v", err)
+	default:
+	}
+
+	group.Signal(syscall.SIGINT)
+	err := <-done
+	Assert(t, err != nil && strings.Contains(err.Error(), "interrupt"), "group must stop on the interrupt, got: %v", err)
+}
@@ -0,0 +1,72 @@
+package servicegroup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ErrBindFailed is matched, with errors.Is, by the error Run returns when one of the Group's servers can't bind its
+// listener, eg because the port is already in use. Run binds every listener before starting any workers, so it
+// fails fast with nothing to shut down.
+var ErrBindFailed = errors.New("servicegroup: failed to bind listener")
+
+// ErrShutdown is the error Run returns when the Group was stopped with Shutdown.
+var ErrShutdown = errors.New("servicegroup: Shutdown called")
+
+// ServerError is returned by Run when one of the Group's servers fails to bind its listener or fails while serving,
+// naming which one so callers can tell eg a port conflict on the service server from a debug server failure. Use
+// errors.As to retrieve it.
+type ServerError struct {
+	Component string // "service", "debug", an AddNamedServer server's name, or "added" and the address for AddServer's
+	Addr      string // the address the server was configured to listen on
+	Err       error  // the underlying bind or serve error
+	Bind      bool   // whether the listener failed to bind, so the error matches ErrBindFailed
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s server on %s failed: %s", e.Component, e.Addr, e.Err)
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is ErrBindFailed, ie the server failed to bind its listener.
+func (e *ServerError) Is(target error) bool {
+	return target == ErrBindFailed && e.Bind
+}
+
+// WorkerError is returned by Run when a worker added with AddNamed fails, naming which one. Use errors.As to
+// retrieve it.
+type WorkerError struct {
+	Name string // the name the worker was added with
+	Err  error  // the error the worker returned
+}
+
+func (e *WorkerError) Error() string {
+	return fmt.Sprintf("worker %s failed: %s", e.Name, e.Err)
+}
+
+func (e *WorkerError) Unwrap() error {
+	return e.Err
+}
+
+// signalShutdown is the error the signal watcher stops the Group with on one of its ShutdownSignals.
+type signalShutdown struct {
+	sig os.Signal
+}
+
+func (e *signalShutdown) Error() string {
+	return fmt.Sprintf("stopping on OS signal %s", e.sig)
+}
+
+// serveError wraps an error from http.Server.Serve in a ServerError. http.ErrServerClosed is a clean stop rather than
+// a failure, so it's nil: if the server was closed first, the Group's shutdown reason is whatever closed it.
+func serveError(component, addr string, err error) error {
+	if err == nil || err == http.ErrServerClosed {
+		return nil
+	}
+	return &ServerError{Component: component, Addr: addr, Err: err}
+}
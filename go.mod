@@ -1,5 +1,10 @@
 module github.com/localytics/servicegroup
 
-go 1.12
+go 1.21
 
 require github.com/heptio/workgroup v0.8.0-beta.1
+
+require (
+	golang.org/x/net v0.25.0
+	golang.org/x/text v0.15.0 // indirect
+)
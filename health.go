@@ -0,0 +1,101 @@
+package servicegroup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultHealthCheckTimeout is how long each health check gets when HealthCheckTimeout isn't set.
+const defaultHealthCheckTimeout = time.Second
+
+// healthCheck is a named check registered with RegisterHealthCheck.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// RegisterHealthCheck adds a check (eg a database or cache ping) to the /healthz endpoint on the debug server, which
+// responds 503 if any check fails. Each check is run with a context that times out after HealthCheckTimeout, and
+// fails if it hasn't returned by then. Until a check is registered, /healthz is left to the DebugHandler (or
+// http.DefaultServeMux). It's safe to call while the Group is running; registering a name again replaces that check.
+func (g *Group) RegisterHealthCheck(name string, check func(ctx context.Context) error) {
+	state := g.st()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for i, c := range state.healthChecks {
+		if c.name == name {
+			state.healthChecks[i].check = check
+			return
+		}
+	}
+	state.healthChecks = append(state.healthChecks, healthCheck{name: name, check: check})
+}
+
+// healthReport is the JSON body served by /healthz.
+type healthReport struct {
+	Status  string            `json:"status"`
+	Failing map[string]string `json:"failing,omitempty"` // check name to error
+}
+
+// healthzHandler serves /healthz from the registered health checks, or from fallback while there are none, so a
+// /healthz the debug server's own handler already serves keeps working until a check is registered.
+func (g *Group) healthzHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.state.mu.Lock()
+		checks := append([]healthCheck(nil), g.state.healthChecks...)
+		g.state.mu.Unlock()
+		if len(checks) == 0 {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		g.serveHealthz(w, r, checks)
+	})
+}
+
+// serveHealthz runs checks concurrently and responds 200 if they all pass, otherwise 503 with the failing checks and
+// their errors. A check that hasn't returned by its timeout fails as timed out, even if it ignores its context; it's
+// left to finish in the background.
+func (g *Group) serveHealthz(w http.ResponseWriter, r *http.Request, checks []healthCheck) {
+	timeout := g.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failing := make(map[string]string)
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			result := make(chan error, 1) // buffered, so a check that returns after its timeout doesn't block forever
+			go func() { result <- c.check(ctx) }()
+			var err error
+			select {
+			case err = <-result:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				// past the deadline, whatever the check made of it
+				err = fmt.Errorf("timed out after %s", timeout)
+			}
+			if err != nil {
+				mu.Lock()
+				failing[c.name] = err.Error()
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if len(failing) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, healthReport{Status: "failing", Failing: failing})
+		return
+	}
+	writeJSON(w, http.StatusOK, healthReport{Status: "ok"})
+}
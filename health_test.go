@@ -0,0 +1,84 @@
+package servicegroup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugHandler_HealthzAggregatesChecks(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.HealthCheckTimeout = 50 * time.Millisecond
+	handler := group.debugHandler()
+	get := func() (int, healthReport) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		var report healthReport
+		Ok(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		return rec.Code, report
+	}
+
+	group.RegisterHealthCheck("cache", func(ctx context.Context) error { return nil })
+	code, report := get()
+	Equals(t, http.StatusOK, code)
+	Equals(t, "ok", report.Status)
+
+	group.RegisterHealthCheck("db", func(ctx context.Context) error { return fmt.Errorf("connection refused") })
+	group.RegisterHealthCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done() // hangs until the per-check timeout
+		return ctx.Err()
+	})
+	code, report = get()
+	Equals(t, http.StatusServiceUnavailable, code)
+	Equals(t, "failing", report.Status)
+	Equals(t, map[string]string{"db": "connection refused", "slow": "timed out after 50ms"}, report.Failing)
+}
+
+func TestDebugHandler_HealthzTimesOutChecksIgnoringTheirContext(t *testing.T) {
+	// * Register a check that ignores its context and passes only well after the per-check timeout
+	// * Validate /healthz responds once the timeout passes, with the check failing as timed out
+	group := NewGroup(http.NotFoundHandler())
+	group.HealthCheckTimeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	group.RegisterHealthCheck("stuck", func(ctx context.Context) error {
+		<-release // eg a driver call that takes no context
+		return nil
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	Assert(t, time.Since(start) < time.Second, "/healthz must not wait for a check past its timeout, took %s", time.Since(start))
+	Equals(t, http.StatusServiceUnavailable, rec.Code)
+	var report healthReport
+	Ok(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	Equals(t, map[string]string{"stuck": "timed out after 50ms"}, report.Failing)
+}
+
+func TestDebugHandler_HealthzFallsBackWithoutChecks(t *testing.T) {
+	// * Serve /healthz from the DebugHandler, then register a health check
+	// * Validate the DebugHandler's /healthz is served until the first check is registered
+	debug := http.NewServeMux()
+	debug.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "custom")
+	})
+	group := NewGroup(http.NotFoundHandler())
+	group.DebugHandler = debug
+	handler := group.debugHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	Equals(t, "custom", rec.Body.String(), "without checks the DebugHandler's /healthz must be served")
+
+	group.RegisterHealthCheck("cache", func(ctx context.Context) error { return nil })
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	var report healthReport
+	Ok(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	Equals(t, "ok", report.Status)
+}
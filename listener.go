@@ -0,0 +1,198 @@
+package servicegroup
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// PauseAccepting stops the service server from accepting new connections, without affecting connections it's already
+// serving, until ResumeAccepting is called or the Run ends. New clients wait in the OS listen backlog in the
+// meantime. Safe to call whether or not the Group is running; called before Run, the next Run starts paused.
+func (g *Group) PauseAccepting() {
+	g.st().accepting.close()
+}
+
+// ResumeAccepting undoes PauseAccepting.
+func (g *Group) ResumeAccepting() {
+	g.st().accepting.open()
+}
+
+// gate is a reusable open/closed barrier that goroutines can wait on.
+type gate struct {
+	mu     sync.Mutex
+	opened chan struct{} // closed while the gate is open
+}
+
+// newGate returns an open gate.
+func newGate() *gate {
+	opened := make(chan struct{})
+	close(opened)
+	return &gate{opened: opened}
+}
+
+func (g *gate) open() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.opened:
+	default:
+		close(g.opened)
+	}
+}
+
+func (g *gate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.opened:
+		g.opened = make(chan struct{})
+	default:
+	}
+}
+
+// wait returns a channel that's closed once the gate is open.
+func (g *gate) wait() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.opened
+}
+
+// pausableListener only hands connections to its server while its gate is open.
+type pausableListener struct {
+	net.Listener
+	gate      *gate
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPausableListener(l net.Listener, gate *gate) *pausableListener {
+	return &pausableListener{Listener: l, gate: gate, closed: make(chan struct{})}
+}
+
+// Accept waits for the gate to be open both before accepting a connection and before returning it, so a connection
+// accepted just as the listener was paused is held back until it resumes.
+func (l *pausableListener) Accept() (net.Conn, error) {
+	if !l.waitOpen() {
+		return l.Listener.Accept() // closed; returns the underlying listener's close error
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.waitOpen() {
+		conn.Close()
+		return l.Listener.Accept()
+	}
+	return conn, nil
+}
+
+// waitOpen blocks until the gate is open, returning false if the listener is closed first.
+func (l *pausableListener) waitOpen() bool {
+	select {
+	case <-l.gate.wait():
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *pausableListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// sockoptListener applies socket options to the TCP connections it accepts.
+type sockoptListener struct {
+	net.Listener
+	noDelay         *bool
+	readBufferSize  int
+	writeBufferSize int
+	logf            func(format string, args ...interface{})
+}
+
+func (l *sockoptListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if l.noDelay != nil {
+		err = tcp.SetNoDelay(*l.noDelay)
+	}
+	if err == nil && l.readBufferSize > 0 {
+		err = tcp.SetReadBuffer(l.readBufferSize)
+	}
+	if err == nil && l.writeBufferSize > 0 {
+		err = tcp.SetWriteBuffer(l.writeBufferSize)
+	}
+	if err != nil {
+		l.logf("Error setting socket options on connection from %s: %s", conn.RemoteAddr(), err)
+	}
+	return conn, nil
+}
+
+// SlowStart ramps up the rate the service server accepts new connections at after Run starts, so a cold instance
+// can warm its caches before taking full load: the rate rises linearly from InitialRate to FinalRate over
+// RampDuration, after which it's unlimited. Connections beyond the current rate wait in the OS listen backlog rather
+// than being refused.
+type SlowStart struct {
+	InitialRate  float64       // connections per second accepted at startup
+	FinalRate    float64       // connections per second accepted at the end of the ramp (default 10 times InitialRate)
+	RampDuration time.Duration // how long the ramp lasts
+}
+
+// rate returns the connections per second the ramp allows elapsed after it started.
+func (s SlowStart) rate(elapsed time.Duration) float64 {
+	final := s.FinalRate
+	if final <= 0 {
+		final = 10 * s.InitialRate
+	}
+	return s.InitialRate + (final-s.InitialRate)*float64(elapsed)/float64(s.RampDuration)
+}
+
+// enabled reports whether s limits anything.
+func (s SlowStart) enabled() bool {
+	return s.InitialRate > 0 && s.RampDuration > 0
+}
+
+// slowStartListener spaces out Accept calls according to its SlowStart ramp.
+type slowStartListener struct {
+	net.Listener
+	ramp       SlowStart
+	start      time.Time
+	lastAccept time.Time
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+func newSlowStartListener(l net.Listener, ramp SlowStart) *slowStartListener {
+	return &slowStartListener{Listener: l, ramp: ramp, start: time.Now(), closed: make(chan struct{})}
+}
+
+// Accept waits until the ramp allows another connection, then accepts it. The minimum interval between connections
+// is one over the ramp's current rate, until the ramp is over.
+func (l *slowStartListener) Accept() (net.Conn, error) {
+	if elapsed := time.Since(l.start); elapsed < l.ramp.RampDuration {
+		interval := time.Duration(float64(time.Second) / l.ramp.rate(elapsed))
+		if wait := time.Until(l.lastAccept.Add(interval)); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-l.closed:
+				timer.Stop()
+			}
+		}
+	}
+	conn, err := l.Listener.Accept()
+	l.lastAccept = time.Now()
+	return conn, err
+}
+
+func (l *slowStartListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
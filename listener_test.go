@@ -0,0 +1,89 @@
+package servicegroup
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPausableListener_HoldsConnectionsWhilePaused(t *testing.T) {
+	group := NewGroup(nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	listener := newPausableListener(l, group.state.accepting)
+	defer listener.Close()
+
+	group.PauseAccepting()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	Ok(t, err)
+	defer client.Close()
+
+	select {
+	case <-accepted:
+		Assert(t, false, "connection must not be accepted while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	group.ResumeAccepting()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		Assert(t, false, "connection must be accepted once resumed")
+	}
+}
+
+func TestSockoptListener_AppliesOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	noDelay := false
+	listener := &sockoptListener{Listener: l, noDelay: &noDelay, readBufferSize: 64 << 10, writeBufferSize: 64 << 10, logf: t.Logf}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	Ok(t, err)
+	defer client.Close()
+	conn, err := listener.Accept()
+	Ok(t, err)
+	defer conn.Close()
+	_, ok := conn.(*net.TCPConn)
+	Assert(t, ok, "accepted connection must still be a *net.TCPConn, got %T", conn)
+}
+
+func TestSlowStartListener_SpacesOutAccepts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	listener := newSlowStartListener(l, SlowStart{InitialRate: 10, RampDuration: time.Minute})
+	defer listener.Close()
+
+	for i := 0; i < 3; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		Ok(t, err)
+		defer client.Close()
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		conn, err := listener.Accept()
+		Ok(t, err)
+		conn.Close()
+	}
+	// the first accept is immediate, then at ~10/s the next two take ~100ms each
+	Assert(t, time.Since(start) >= 150*time.Millisecond, "accepts must be rate limited, took %s", time.Since(start))
+}
+
+func TestSlowStart_RampsTheRateLinearly(t *testing.T) {
+	ramp := SlowStart{InitialRate: 10, FinalRate: 50, RampDuration: time.Minute}
+	Equals(t, 10.0, ramp.rate(0))
+	Equals(t, 30.0, ramp.rate(30*time.Second), "halfway through, the rate must be halfway between the two")
+	Equals(t, 50.0, ramp.rate(time.Minute))
+
+	ramp.FinalRate = 0
+	Equals(t, 55.0, ramp.rate(30*time.Second), "without a FinalRate, the ramp must end at 10 times InitialRate")
+}
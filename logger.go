@@ -0,0 +1,53 @@
+package servicegroup
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+)
+
+// Logger is what the Group writes its lifecycle messages to. *log.Logger satisfies it, and adapting a structured
+// logger (zap's SugaredLogger.Infof, logrus' Printf) takes a one-line wrapper at most.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// stdLogger is the default Logger, writing through the standard log package's logger.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// logf writes a message to the Group's Logger, or the standard log package's logger if it's nil.
+func (g *Group) logf(format string, args ...interface{}) {
+	if g.Logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	g.Logger.Printf(format, args...)
+}
+
+// errorLog returns a *log.Logger for the Group's http.Servers' ErrorLog, so the errors net/http logs (eg TLS
+// handshake failures) go to the Group's Logger too.
+func (g *Group) errorLog() *log.Logger {
+	logf := g.logf
+	if g.SuppressClientDisconnectLogs {
+		logf = func(format string, args ...interface{}) {
+			if line := fmt.Sprintf(format, args...); !strings.Contains(line, syscall.EPIPE.Error()) &&
+				!strings.Contains(line, syscall.ECONNRESET.Error()) {
+				g.logf("%s", line)
+			}
+		}
+	}
+	return log.New(logfWriter(logf), "", 0)
+}
+
+// logfWriter writes each message written to it as a line through a logf function.
+type logfWriter func(format string, args ...interface{})
+
+func (w logfWriter) Write(p []byte) (int, error) {
+	w("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
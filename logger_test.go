@@ -0,0 +1,93 @@
+package servicegroup
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewWorkgroup_LogsThroughLogger(t *testing.T) {
+	listener, _ := NewPipeListener()
+	logger := &recordingLogger{}
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.Logger = logger
+	group.Add(func(stop <-chan struct{}) error {
+		return fmt.Errorf("done")
+	})
+
+	_ = group.Run()
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	Assert(t, len(logger.lines) > 0 && logger.lines[0] == "Service starting", "lifecycle messages must go to the Logger, got: %q", logger.lines)
+	Assert(t, strings.HasPrefix(logger.lines[len(logger.lines)-2], "Shutdown complete"), "shutdown summary must go to the Logger, got: %q", logger.lines)
+	Equals(t, "flushed", logger.lines[len(logger.lines)-1], "a Logger with a Flush method must be flushed last")
+}
+
+func TestNewWorkgroup_ServerErrorsGoThroughLogger(t *testing.T) {
+	// * Run a TLS group with a custom Logger and send it something that isn't a TLS handshake
+	// * Validate net/http's handshake error is written to the Logger rather than straight to stderr
+	certPEM, keyPEM := selfSignedCert(t)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	Ok(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	logger := &recordingLogger{}
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	group.Logger = logger
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	Ok(t, err)
+	fmt.Fprint(conn, "not a TLS handshake\r\n\r\n")
+	ioutil.ReadAll(conn)
+	conn.Close()
+	handshakeError := func() bool {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		for _, line := range logger.lines {
+			if strings.HasPrefix(line, "http: TLS handshake error from ") {
+				return true
+			}
+		}
+		return false
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for !handshakeError() {
+		Assert(t, time.Now().Before(deadline), "TLS handshake error must be written to the Logger, got: %q", logger.lines)
+		time.Sleep(time.Millisecond)
+	}
+
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+// recordingLogger is a Logger that keeps the messages written to it, and records being flushed as a message.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) Flush() error {
+	l.Printf("flushed")
+	return nil
+}
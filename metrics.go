@@ -0,0 +1,137 @@
+package servicegroup
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration histogram's buckets; the same defaults as
+// the Prometheus client libraries.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// requestKey identifies a series of the request counter.
+type requestKey struct {
+	route string
+	code  int
+}
+
+// durationHistogram is a cumulative histogram of request durations over durationBuckets.
+type durationHistogram struct {
+	counts []uint64 // observations in each bucket, not cumulative; the last is the +Inf bucket
+	sum    float64  // total of the observed durations, in seconds
+	count  uint64
+}
+
+func (h *durationHistogram) observe(seconds float64) {
+	i := sort.SearchFloat64s(durationBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// httpMetrics records the service server's requests for the /metrics endpoint.
+type httpMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*durationHistogram // by route
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[string]*durationHistogram),
+	}
+}
+
+// observe records a completed request to route.
+func (m *httpMetrics) observe(route string, code int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{route: route, code: code}]++
+	h := m.durations[route]
+	if h == nil {
+		h = &durationHistogram{counts: make([]uint64, len(durationBuckets)+1)}
+		m.durations[route] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// write writes the recorded metrics, plus the in-flight gauge, in the Prometheus text exposition format.
+func (m *httpMetrics) write(w io.Writer, inFlight int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP servicegroup_http_requests_total Requests completed by the service server, by route and status code.")
+	fmt.Fprintln(w, "# TYPE servicegroup_http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "servicegroup_http_requests_total{route=%s,code=\"%d\"} %d\n", labelValue(key.route), key.code, m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP servicegroup_http_request_duration_seconds Time taken to serve service server requests, by route.")
+	fmt.Fprintln(w, "# TYPE servicegroup_http_request_duration_seconds histogram")
+	routes := make([]string, 0, len(m.durations))
+	for route := range m.durations {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h, label := m.durations[route], labelValue(route)
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(w, "servicegroup_http_request_duration_seconds_bucket{route=%s,le=\"%s\"} %d\n", label, le, cumulative)
+		}
+		fmt.Fprintf(w, "servicegroup_http_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "servicegroup_http_request_duration_seconds_sum{route=%s} %s\n", label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "servicegroup_http_request_duration_seconds_count{route=%s} %d\n", label, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP servicegroup_http_requests_in_flight Requests currently being served by the service server.")
+	fmt.Fprintln(w, "# TYPE servicegroup_http_requests_in_flight gauge")
+	fmt.Fprintf(w, "servicegroup_http_requests_in_flight %d\n", inFlight)
+}
+
+// labelEscaper escapes a label value for the Prometheus text exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns s as a quoted label value.
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// metricsRoute returns the route label for r: the pattern it matches if the served Handler is a *http.ServeMux, so
+// the label can't take arbitrarily many values, and otherwise "".
+func (g *Group) metricsRoute(r *http.Request) string {
+	if mux, ok := g.state.handler.Load().(handlerRef).Handler.(*http.ServeMux); ok {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+	return ""
+}
+
+// serveMetrics writes the service server's request metrics in the Prometheus text exposition format.
+func (g *Group) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics := g.state.requestMetrics()
+	if metrics == nil {
+		metrics = newHTTPMetrics() // Run hasn't started the service server; report empty series
+	}
+	metrics.write(w, g.InFlight())
+}
@@ -0,0 +1,49 @@
+package servicegroup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler_MetricsEndpoint(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	group := NewGroup(mux)
+	rec := httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	Equals(t, http.StatusNotFound, rec.Code, "metrics endpoint must be opt-in")
+
+	group.EnableMetrics = true
+	service, debug := group.serviceHandler(), group.debugHandler()
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		debug.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		Equals(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+	Assert(t, strings.Contains(scrape(), "servicegroup_http_requests_in_flight 0\n"), "in-flight gauge must be reported")
+
+	for _, path := range []string{"/users/1", "/users/2"} {
+		service.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	body := scrape()
+	for _, line := range []string{
+		`servicegroup_http_requests_total{route="/users/",code="201"} 2`,
+		`servicegroup_http_request_duration_seconds_bucket{route="/users/",le="+Inf"} 2`,
+		`servicegroup_http_request_duration_seconds_count{route="/users/"} 2`,
+	} {
+		Assert(t, strings.Contains(body, line+"\n"), "metrics must include %q, got:\n%s", line, body)
+	}
+
+	service.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/3", nil))
+	body = scrape()
+	Assert(t, strings.Contains(body, `servicegroup_http_requests_total{route="/users/",code="201"} 3`+"\n"), "request counter must increment, got:\n%s", body)
+
+	group.DebugBasicAuth = &BasicAuth{Username: "ops", Password: "hunter2"}
+	debug = group.debugHandler()
+	Assert(t, strings.Contains(scrape(), "servicegroup_http_requests_total"), "metrics must not require debug credentials")
+}
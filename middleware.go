@@ -0,0 +1,529 @@
+package servicegroup
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// serviceHandler wraps the Group's Handler with the service server's middleware. The Handler is only served until
+// it's first replaced, eg by HandlerReloader: later Runs keep serving the replacement.
+func (g *Group) serviceHandler() http.Handler {
+	if g.state.handler.Load() == nil {
+		g.state.handler.Store(handlerRef{g.Handler})
+	}
+	exempt := make(map[string]bool, len(g.MaintenanceExemptPaths))
+	for _, path := range g.MaintenanceExemptPaths {
+		exempt[path] = true
+	}
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// track requests here, innermost, so deadlines set on the request context by middleware are seen
+		atomic.AddInt64(&g.state.inFlight, 1)
+		defer atomic.AddInt64(&g.state.inFlight, -1)
+		g.state.inflight.start(r)
+		defer g.state.inflight.done(r)
+		if m := g.state.maintenance.Load().(handlerRef); m.Handler != nil && !exempt[r.URL.Path] {
+			m.ServeHTTP(w, r)
+			return
+		}
+		g.state.handler.Load().(handlerRef).ServeHTTP(w, r)
+	})
+	g.state.mu.Lock()
+	middleware := append([]func(http.Handler) http.Handler(nil), g.state.middleware...)
+	g.state.mu.Unlock()
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	if g.RecoverPanics {
+		h = g.recoverPanics(h)
+	}
+	if g.MaxConcurrentRequests > 0 {
+		// inside the request timeout, so a timed-out request holds its slot until its handler actually returns
+		h = g.limitConcurrency(h)
+	}
+	if g.RequestTimeout > 0 {
+		// outside recoverPanics, so a panic is turned into a 500 before the timeout handler re-panics it
+		h = http.TimeoutHandler(h, g.RequestTimeout, g.RequestTimeoutMessage)
+	}
+	if len(g.RequiredHeaders) > 0 {
+		h = g.requireHeaders(h)
+	}
+	if g.ExtractTraceContext != nil {
+		h = g.traceContext(h)
+	}
+	if len(g.ResponseHeaders) > 0 {
+		h = g.setResponseHeaders(h)
+	}
+	if g.GlobalBodyBudget > 0 {
+		h = g.limitBodyBudget(h)
+	}
+	if g.MaxURILength > 0 || g.MaxHeaderCount > 0 {
+		h = g.limitRequestHead(h)
+	}
+	h = g.instrument(h)
+	if len(g.TrustedProxies) > 0 {
+		h = g.trustProxies(h)
+	}
+	if g.EnableProxyProtocol {
+		// outermost, so TrustedProxies sees the peer the PROXY protocol header reports
+		h = proxyProtocolClient(h)
+	}
+	return h
+}
+
+// Use adds mw to the chain of middleware the service server wraps its handler in, eg for authentication. The chain
+// is applied in registration order, outermost first: after Use(a) and Use(b), requests are served by a(b(Handler)).
+// It sits inside the Group's own middleware (RecoverPanics, RequestTimeout and the rest), so it sees requests those
+// let through, and around whichever handler is being served, including one swapped in by HandlerReloader or
+// EnterMaintenance. Call it before Run.
+func (g *Group) Use(mw func(http.Handler) http.Handler) {
+	state := g.st()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.middleware = append(state.middleware, mw)
+}
+
+// instrument records each request's body and response sizes and whether the client disconnected into the Group's
+// runtime stats, the access log, the recent requests buffer and the request metrics, and counts completed requests
+// towards MaxRequests.
+func (g *Group) instrument(next http.Handler) http.Handler {
+	var recent *accessLogBuffer
+	if g.AccessLogBufferSize > 0 {
+		recent = newAccessLogBuffer(g.AccessLogBufferSize)
+	}
+	g.state.setRecentRequests(recent)
+	var metrics *httpMetrics
+	if g.EnableMetrics {
+		metrics = newHTTPMetrics()
+	}
+	g.state.setRequestMetrics(metrics)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		entry := AccessLogEntry{
+			Time:             start,
+			RemoteAddr:       r.RemoteAddr,
+			Method:           r.Method,
+			URI:              r.RequestURI,
+			Proto:            r.Proto,
+			Status:           rw.status,
+			BytesIn:          body.n,
+			BytesOut:         rw.bytes,
+			Duration:         time.Since(start),
+			Referer:          r.Referer(),
+			UserAgent:        r.UserAgent(),
+			ClientDisconnect: isClientDisconnect(rw.err) || r.Context().Err() == context.Canceled,
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK // nothing written; net/http sends an empty 200
+		}
+		atomic.AddInt64(&g.state.bytesIn, entry.BytesIn)
+		atomic.AddInt64(&g.state.bytesOut, entry.BytesOut)
+		if entry.ClientDisconnect {
+			atomic.AddInt64(&g.state.clientDisconnects, 1)
+		}
+		if g.EnableAccessLog && !(entry.ClientDisconnect && g.SuppressClientDisconnectLogs) {
+			g.logAccess(entry)
+		}
+		if recent != nil {
+			recent.add(entry)
+		}
+		if metrics != nil {
+			metrics.observe(g.metricsRoute(r), entry.Status, entry.Duration)
+		}
+		if n := atomic.AddInt64(&g.state.requests, 1); n == g.MaxRequests {
+			g.triggerShutdown(fmt.Errorf("served MaxRequests (%d requests)", n))
+		}
+	})
+}
+
+// EnterMaintenance routes every service request, except those to MaintenanceExemptPaths, to handler until
+// ExitMaintenance is called; the process and its connections stay up. A nil handler responds 503 Service Unavailable.
+func (g *Group) EnterMaintenance(handler http.Handler) {
+	if handler == nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		})
+	}
+	g.st().maintenance.Store(handlerRef{handler})
+	g.logf("Entered maintenance mode")
+}
+
+// ExitMaintenance returns the service server to its regular handler after EnterMaintenance.
+func (g *Group) ExitMaintenance() {
+	g.st().maintenance.Store(handlerRef{})
+	g.logf("Exited maintenance mode")
+}
+
+// recoverPanics turns a panic in next into a 500 response and a logged stack trace, rather than net/http's default of
+// dropping the connection. http.ErrAbortHandler is re-panicked, since it's how handlers deliberately abort a response.
+func (g *Group) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				g.logf("Panic serving %s %s: %v\n%s", r.Method, r.URL, err, debug.Stack())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireHeaders rejects requests that don't carry all of the RequiredHeaders with a 403, except on skipped paths.
+func (g *Group) requireHeaders(next http.Handler) http.Handler {
+	skip := make(map[string]bool, len(g.RequiredHeadersSkipPaths))
+	for _, path := range g.RequiredHeadersSkipPaths {
+		skip[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !skip[r.URL.Path] {
+			for name, value := range g.RequiredHeaders {
+				if subtle.ConstantTimeCompare([]byte(r.Header.Get(name)), []byte(value)) != 1 {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustProxies sets RemoteAddr from X-Forwarded-For on requests from the TrustedProxies, handing next a copy of the
+// request.
+func (g *Group) trustProxies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client := g.forwardedFor(r); client != nil {
+			forwarded := *r
+			forwarded.RemoteAddr = client.String()
+			r = &forwarded
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns the client IP X-Forwarded-For gives for r, or nil if r's peer isn't a trusted proxy or the
+// header doesn't name a valid client. Entries are walked right to left, from the peer, past every trusted proxy; or
+// with TrustLeftmostForwardedFor, left to right to the first that isn't a trusted proxy.
+func (g *Group) forwardedFor(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if peer := net.ParseIP(host); peer == nil || !g.trustedProxy(peer) {
+		return nil
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if g.TrustLeftmostForwardedFor {
+		for _, hop := range hops {
+			client := net.ParseIP(strings.TrimSpace(hop))
+			if client == nil {
+				return nil
+			}
+			if !g.trustedProxy(client) {
+				return client
+			}
+		}
+		return nil // every hop is a trusted proxy, so there's no client to take
+	}
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		if client = net.ParseIP(strings.TrimSpace(hops[i])); client == nil {
+			return nil
+		}
+		if !g.trustedProxy(client) {
+			break
+		}
+	}
+	return client // the left-most entry if every hop is trusted
+}
+
+// trustedProxy reports whether ip is in one of the TrustedProxies.
+func (g *Group) trustedProxy(ip net.IP) bool {
+	for _, network := range g.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setResponseHeaders sets the ResponseHeaders on every response before handing off to next.
+func (g *Group) setResponseHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range g.ResponseHeaders {
+			w.Header().Set(name, value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// traceContext replaces each request's context with the one returned by ExtractTraceContext.
+func (g *Group) traceContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctx := g.ExtractTraceContext(r); ctx != nil {
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitConcurrency sheds requests with a 503 while MaxConcurrentRequests requests are already being served.
+func (g *Group) limitConcurrency(next http.Handler) http.Handler {
+	slots := make(chan struct{}, g.MaxConcurrentRequests)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-slots }() // deferred so a panicking handler still frees its slot
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRequestHead rejects requests whose URI is longer than MaxURILength with a 414, and those with more header
+// fields than MaxHeaderCount with a 431.
+func (g *Group) limitRequestHead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.MaxURILength > 0 && len(r.RequestURI) > g.MaxURILength {
+			http.Error(w, http.StatusText(http.StatusRequestURITooLong), http.StatusRequestURITooLong)
+			return
+		}
+		if g.MaxHeaderCount > 0 {
+			count := 0
+			for _, values := range r.Header {
+				count += len(values)
+			}
+			if count > g.MaxHeaderCount {
+				http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errBodyBudgetExceeded is returned by request body reads that would take the Group over its GlobalBodyBudget.
+var errBodyBudgetExceeded = errors.New("servicegroup: global request body budget exceeded")
+
+// limitBodyBudget sheds requests with a 503 while the GlobalBodyBudget is used up by other in-flight request bodies,
+// and accounts the bytes each admitted request reads against it until the request completes.
+func (g *Group) limitBodyBudget(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		used := atomic.LoadInt64(&g.state.bodyBytes)
+		if used >= g.GlobalBodyBudget || (r.ContentLength > 0 && used+r.ContentLength > g.GlobalBodyBudget) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		body := &budgetReader{ReadCloser: r.Body, used: &g.state.bodyBytes, budget: g.GlobalBodyBudget}
+		defer body.release()
+		r.Body = body
+		next.ServeHTTP(w, r)
+	})
+}
+
+// budgetReader counts the bytes read through it against a budget shared by all in-flight requests.
+type budgetReader struct {
+	io.ReadCloser
+	used   *int64
+	budget int64
+	n      int64
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		if atomic.AddInt64(r.used, int64(n)) > r.budget {
+			return n, errBodyBudgetExceeded
+		}
+	}
+	return n, err
+}
+
+// release returns the bytes read through r to the budget.
+func (r *budgetReader) release() {
+	atomic.AddInt64(r.used, -r.n)
+}
+
+// BytesStats returns the total request body bytes read and response bytes written by the service handler since the
+// Group was created.
+func (g *Group) BytesStats() (in, out int64) {
+	state := g.st()
+	return atomic.LoadInt64(&state.bytesIn), atomic.LoadInt64(&state.bytesOut)
+}
+
+// ClientDisconnects returns the number of service requests whose client went away (cancelled the request or closed
+// the connection) before the response was finished.
+func (g *Group) ClientDisconnects() int64 {
+	return atomic.LoadInt64(&g.st().clientDisconnects)
+}
+
+// InFlight returns the number of requests the service handler is currently serving, as reported by the state
+// endpoint's in_flight.
+func (g *Group) InFlight() int64 {
+	return atomic.LoadInt64(&g.st().inFlight)
+}
+
+// WaitForDrain blocks until the service handler has no requests in flight, eg to coordinate external drain logic
+// after EnterMaintenance or PauseAccepting, returning ctx's error if it's done first.
+func (g *Group) WaitForDrain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for g.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// isClientDisconnect reports whether err, returned while writing a response, means the client's connection is gone.
+func isClientDisconnect(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// responseWriter wraps an http.ResponseWriter to record the response status, size and first write error, while still
+// exposing the http.Flusher and http.Hijacker interfaces of the underlying writer.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	err    error
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// requestTracker follows a server's in-flight requests and their context deadlines.
+type requestTracker struct {
+	mu        sync.Mutex
+	deadlines map[*http.Request]time.Time // zero if the request has no deadline
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{deadlines: make(map[*http.Request]time.Time)}
+}
+
+func (t *requestTracker) start(r *http.Request) {
+	deadline, _ := r.Context().Deadline()
+	t.mu.Lock()
+	t.deadlines[r] = deadline
+	t.mu.Unlock()
+}
+
+func (t *requestTracker) done(r *http.Request) {
+	t.mu.Lock()
+	delete(t.deadlines, r)
+	t.mu.Unlock()
+}
+
+// wait blocks until there are no requests in flight, or returns context.DeadlineExceeded once deadline passes and
+// context.Canceled once force is closed.
+func (t *requestTracker) wait(deadline time.Time, force <-chan struct{}) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		t.mu.Lock()
+		n := len(t.deadlines)
+		t.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return context.DeadlineExceeded
+		}
+		select {
+		case <-force:
+			return context.Canceled
+		case <-ticker.C:
+		}
+	}
+}
+
+// latestDeadline returns the latest context deadline of the in-flight requests; ok is false if there are no
+// requests in flight or any of them has no deadline.
+func (t *requestTracker) latestDeadline() (latest time.Time, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, deadline := range t.deadlines {
+		if deadline.IsZero() {
+			return time.Time{}, false
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	return latest, len(t.deadlines) > 0
+}
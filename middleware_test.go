@@ -0,0 +1,450 @@
+package servicegroup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServiceHandler_CountsBytes(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		Ok(t, err)
+		fmt.Fprintf(w, "read %s", body)
+	}))
+	handler := group.serviceHandler()
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+		Equals(t, "read hello", rec.Body.String())
+	}
+
+	in, out := group.BytesStats()
+	Equals(t, int64(10), in, "request bytes")
+	Equals(t, int64(20), out, "response bytes")
+}
+
+func TestServiceHandler_RequiredHeaders(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	group.RequiredHeaders = map[string]string{"X-Gateway-Auth": "secret"}
+	group.RequiredHeadersSkipPaths = []string{"/ping"}
+	handler := group.serviceHandler()
+
+	cases := []struct {
+		path   string
+		header string
+		status int
+	}{
+		{"/work", "", http.StatusForbidden},
+		{"/work", "wrong", http.StatusForbidden},
+		{"/work", "secret", http.StatusOK},
+		{"/ping", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.path, nil)
+		if c.header != "" {
+			req.Header.Set("X-Gateway-Auth", c.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Equals(t, c.status, rec.Code, "%s with header %q", c.path, c.header)
+	}
+}
+
+func TestServiceHandler_TrustedProxies(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	}))
+	_, cdn, _ := net.ParseCIDR("10.0.0.0/8")
+	group.TrustedProxies = []net.IPNet{*cdn}
+	handler := group.serviceHandler()
+
+	cases := []struct {
+		peer   string
+		xff    string
+		remote string
+	}{
+		{"10.1.2.3:4567", "203.0.113.7", "203.0.113.7"},
+		{"10.1.2.3:4567", "198.51.100.1, 203.0.113.7, 10.9.9.9", "203.0.113.7"}, // a spoofed left-most entry is ignored
+		{"10.1.2.3:4567", "", "10.1.2.3:4567"},
+		{"10.1.2.3:4567", "not-an-ip", "10.1.2.3:4567"},
+		{"192.0.2.1:4567", "203.0.113.7", "192.0.2.1:4567"}, // untrusted peers can't set their address
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.peer
+		if c.xff != "" {
+			req.Header.Set("X-Forwarded-For", c.xff)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Equals(t, c.remote, rec.Body.String(), "peer %s with X-Forwarded-For %q", c.peer, c.xff)
+	}
+
+	group.TrustLeftmostForwardedFor = true
+	handler = group.serviceHandler()
+	for _, c := range []struct {
+		xff    string
+		remote string
+	}{
+		{"198.51.100.1, 203.0.113.7, 10.9.9.9", "198.51.100.1"},
+		{"10.8.8.8, 203.0.113.7", "203.0.113.7"}, // trusted proxies are skipped from the left too
+		{"10.8.8.8, 10.9.9.9", "10.1.2.3:4567"},  // no untrusted entry leaves the peer's address
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+		req.Header.Set("X-Forwarded-For", c.xff)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Equals(t, c.remote, rec.Body.String(), "left-most with X-Forwarded-For %q", c.xff)
+	}
+}
+
+func TestServiceHandler_ReloadsHandler(t *testing.T) {
+	respond := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		})
+	}
+	group := NewGroup(respond("original"))
+	handler := group.serviceHandler()
+	get := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Body.String()
+	}
+
+	group.HandlerReloader = func() (http.Handler, error) { return nil, fmt.Errorf("bad config") }
+	group.reloadHandler()
+	Equals(t, "original", get(), "failed reload must keep the current handler")
+
+	group.HandlerReloader = func() (http.Handler, error) { return respond("reloaded"), nil }
+	group.reloadHandler()
+	Equals(t, "reloaded", get(), "successful reload must swap the handler")
+}
+
+func TestServiceHandler_MaxRequestsTriggersShutdown(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.MaxRequests = 2
+	handler := group.serviceHandler()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	select {
+	case err := <-group.state.trigger:
+		Assert(t, false, "shutdown triggered early: %s", err)
+	default:
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	select {
+	case err := <-group.state.trigger:
+		Assert(t, strings.Contains(err.Error(), "MaxRequests"), "unexpected shutdown reason: %s", err)
+	default:
+		Assert(t, false, "shutdown must be triggered once MaxRequests is reached")
+	}
+}
+
+func TestServiceHandler_ExtractTraceContext(t *testing.T) {
+	type traceKey struct{}
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value(traceKey{}))
+	}))
+	group.ExtractTraceContext = func(r *http.Request) context.Context {
+		return context.WithValue(r.Context(), traceKey{}, r.Header.Get("traceparent"))
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	group.serviceHandler().ServeHTTP(rec, req)
+	Equals(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", rec.Body.String())
+}
+
+func TestServiceHandler_CountsClientDisconnects(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	handler := group.serviceHandler()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	Equals(t, int64(0), group.ClientDisconnects(), "completed request must not count as a disconnect")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	Equals(t, int64(1), group.ClientDisconnects(), "cancelled request must count as a disconnect")
+
+	handler.ServeHTTP(brokenPipeWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/", nil))
+	Equals(t, int64(2), group.ClientDisconnects(), "broken pipe must count as a disconnect")
+}
+
+// brokenPipeWriter fails every write the way a net/http response does once the client has hung up.
+type brokenPipeWriter struct {
+	http.ResponseWriter
+}
+
+func (brokenPipeWriter) Write([]byte) (int, error) {
+	return 0, &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+}
+
+func TestServiceHandler_MaintenanceMode(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	group.MaintenanceExemptPaths = []string{"/ping"}
+	handler := group.serviceHandler()
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	group.EnterMaintenance(nil)
+	Equals(t, http.StatusServiceUnavailable, get("/work"), "maintenance must serve 503s")
+	Equals(t, http.StatusOK, get("/ping"), "exempt paths must bypass maintenance")
+
+	group.ExitMaintenance()
+	Equals(t, http.StatusOK, get("/work"), "regular handler must be restored")
+}
+
+func TestServiceHandler_ResponseHeaders(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "handler")
+	}))
+	group.ResponseHeaders = map[string]string{"X-Content-Type-Options": "nosniff", "Server": "servicegroup"}
+
+	rec := httptest.NewRecorder()
+	group.serviceHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	Equals(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	Equals(t, "handler", rec.Header().Get("Server"), "handler must be able to override response headers")
+}
+
+func TestServiceHandler_GlobalBodyBudget(t *testing.T) {
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/hold" {
+			close(reading)
+			<-release
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+	group.GlobalBodyBudget = 10
+	handler := group.serviceHandler()
+	post := func(path, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.ContentLength = -1 // unknown length, so only the bytes actually read count
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	held := make(chan int)
+	go func() { held <- post("/hold", "0123456789") }()
+	<-reading
+	Equals(t, http.StatusServiceUnavailable, post("/", "x"), "budget used up by the held request must shed new ones")
+	close(release)
+	Equals(t, http.StatusOK, <-held)
+
+	Equals(t, http.StatusOK, post("/", "0123456789"), "completed requests must release their bytes")
+	Equals(t, http.StatusRequestEntityTooLarge, post("/", "0123456789a"), "reads beyond the budget must fail")
+	Equals(t, int64(0), atomic.LoadInt64(&group.state.bodyBytes))
+}
+
+func TestServiceHandler_LimitsURILengthAndHeaderCount(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	group.MaxURILength = 16
+	group.MaxHeaderCount = 2
+	handler := group.serviceHandler()
+
+	cases := []struct {
+		uri     string
+		headers int
+		status  int
+	}{
+		{"/short", 2, http.StatusOK},
+		{"/sixteen-chars-x", 0, http.StatusOK},
+		{"/seventeen-chars-x", 0, http.StatusRequestURITooLong},
+		{"/short", 3, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.uri, nil)
+		for i := 0; i < c.headers; i++ {
+			req.Header.Add("X-Repeated", "v")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Equals(t, c.status, rec.Code, "%s with %d headers", c.uri, c.headers)
+	}
+}
+
+func TestServiceHandler_RecoversPanics(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/abort":
+			panic(http.ErrAbortHandler)
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	group.RecoverPanics = true
+	group.Logger = &recordingLogger{}
+	handler := group.serviceHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	Equals(t, http.StatusInternalServerError, rec.Code)
+	lines := group.Logger.(*recordingLogger).lines
+	Assert(t, len(lines) == 1 && strings.Contains(lines[0], "boom") && strings.Contains(lines[0], "goroutine"), "panic must be logged with its stack, got: %q", lines)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/ok", nil))
+	Equals(t, "ok", rec.Body.String(), "requests after a panic must still be served")
+
+	defer func() {
+		Equals(t, http.ErrAbortHandler, recover(), "http.ErrAbortHandler must not be swallowed")
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+}
+
+func TestServiceHandler_InFlightAndWaitForDrain(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	handler := group.serviceHandler()
+	Equals(t, int64(0), group.InFlight())
+
+	const requests = 3
+	finished := make(chan struct{}, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			finished <- struct{}{}
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		<-started
+	}
+	Equals(t, int64(requests), group.InFlight(), "InFlight must count the requests being served")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	Equals(t, context.DeadlineExceeded, group.WaitForDrain(ctx), "WaitForDrain must give up when its context expires")
+
+	close(release)
+	Ok(t, group.WaitForDrain(context.Background()))
+	Equals(t, int64(0), group.InFlight())
+	for i := 0; i < requests; i++ {
+		<-finished
+	}
+}
+
+func TestServiceHandler_RequestTimeout(t *testing.T) {
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		<-r.Context().Done()
+	}))
+	group.RequestTimeout = 20 * time.Millisecond
+	group.RequestTimeoutMessage = "took too long"
+	group.RecoverPanics = true
+	handler := group.serviceHandler()
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	Equals(t, http.StatusServiceUnavailable, rec.Code)
+	Equals(t, "took too long", rec.Body.String())
+	Assert(t, time.Since(start) < time.Second, "slow request must be cut off at the timeout, took %s", time.Since(start))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	Equals(t, http.StatusInternalServerError, rec.Code, "panics must still be recovered under a request timeout")
+}
+
+func TestServiceHandler_MaxConcurrentRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		started <- struct{}{}
+		<-release
+	}))
+	group.MaxConcurrentRequests = 2
+	handler := group.serviceHandler()
+
+	const requests = 10
+	codes := make(chan *httptest.ResponseRecorder, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			codes <- rec
+		}()
+	}
+	<-started
+	<-started
+	for i := 0; i < requests-2; i++ {
+		rec := <-codes
+		Equals(t, http.StatusServiceUnavailable, rec.Code, "requests past the limit must be shed")
+		Equals(t, "1", rec.Header().Get("Retry-After"))
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		Equals(t, http.StatusOK, (<-codes).Code, "requests within the limit must be served")
+	}
+
+	for i := 0; i < 3; i++ {
+		func() {
+			defer func() { recover() }()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+		}()
+	}
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("panicking requests must free their slots")
+	}
+}
+
+func TestServiceHandler_UseAppliesMiddlewareInOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" before")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" after")
+			})
+		}
+	}
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}))
+	group.Use(trace("first"))
+	group.Use(trace("second"))
+
+	group.serviceHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	Equals(t, []string{"first before", "second before", "handler", "second after", "first after"}, calls)
+}
@@ -0,0 +1,71 @@
+package servicegroup
+
+import "time"
+
+// Option configures a Group at construction time; pass any number of them to NewGroupWithOptions (or NewGroup).
+// Options are applied in order after the defaults are set, so later options override earlier ones.
+type Option func(*Group)
+
+// WithShutdownTimeout sets the Group's ShutdownTimeout.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(g *Group) {
+		g.ShutdownTimeout = timeout
+	}
+}
+
+// WithServiceAddr sets the address the service server listens on, eg ":8080".
+func WithServiceAddr(addr string) Option {
+	return func(g *Group) {
+		g.ServiceServerAddr = addr
+	}
+}
+
+// WithDebugAddr sets the address the debug server listens on, eg ":6060".
+func WithDebugAddr(addr string) Option {
+	return func(g *Group) {
+		g.DebugServerAddr = addr
+	}
+}
+
+// WithDisableDebug skips starting the debug server entirely; it's the same as WithDebugServerDisabled.
+func WithDisableDebug() Option {
+	return WithDebugServerDisabled()
+}
+
+// WithPprofDisabled hides the /debug/pprof endpoints on the debug server. Anything else registered on the default
+// ServeMux (eg expvars) is still served.
+func WithPprofDisabled() Option {
+	return func(g *Group) {
+		g.DisablePprof = true
+	}
+}
+
+// WithDebugServerDisabled skips starting the debug server entirely.
+func WithDebugServerDisabled() Option {
+	return func(g *Group) {
+		g.DisableDebugServer = true
+	}
+}
+
+// WithSignalWatcherDisabled skips watching for OS interrupt signals; the Group will only shut down when one of its
+// workers ends.
+func WithSignalWatcherDisabled() Option {
+	return func(g *Group) {
+		g.DisableSignalWatcher = true
+	}
+}
+
+// WithKeepAlivesDisabled disables HTTP keep-alives on the service server, so every response closes its connection.
+func WithKeepAlivesDisabled() Option {
+	return func(g *Group) {
+		g.DisableKeepAlives = true
+	}
+}
+
+// WithServiceNetwork sets the network the service server listens on: "tcp4" or "tcp6" to pin ServiceServerAddr to
+// one IP stack, rather than leaving it to the OS as "tcp" does, or "unix".
+func WithServiceNetwork(network string) Option {
+	return func(g *Group) {
+		g.ServiceNetwork = network
+	}
+}
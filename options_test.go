@@ -0,0 +1,46 @@
+package servicegroup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewGroup_ToggleOptions(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler(),
+		WithPprofDisabled(),
+		WithDebugServerDisabled(),
+		WithSignalWatcherDisabled(),
+		WithKeepAlivesDisabled(),
+	)
+	Assert(t, group.DisablePprof, "WithPprofDisabled must set DisablePprof")
+	Assert(t, group.DisableDebugServer, "WithDebugServerDisabled must set DisableDebugServer")
+	Assert(t, group.DisableSignalWatcher, "WithSignalWatcherDisabled must set DisableSignalWatcher")
+	Assert(t, group.DisableKeepAlives, "WithKeepAlivesDisabled must set DisableKeepAlives")
+
+	rec := httptest.NewRecorder()
+	group.debugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	Equals(t, http.StatusNotFound, rec.Code, "pprof must be hidden when disabled")
+}
+
+func TestNewGroupWithOptions_OverridesDefaults(t *testing.T) {
+	defaults := NewGroupWithOptions(http.NotFoundHandler())
+	Equals(t, 30*time.Second, defaults.ShutdownTimeout)
+	Equals(t, ":8080", defaults.ServiceServerAddr)
+	Equals(t, ":6060", defaults.DebugServerAddr)
+	Assert(t, !defaults.DisableDebugServer, "debug server must be enabled by default")
+
+	group := NewGroupWithOptions(http.NotFoundHandler(),
+		WithShutdownTimeout(5*time.Second),
+		WithServiceAddr(":9090"),
+		WithDebugAddr("127.0.0.1:7070"),
+		WithDisableDebug(),
+		WithServiceAddr(":9091"), // later options override earlier ones
+	)
+	Equals(t, 5*time.Second, group.ShutdownTimeout)
+	Equals(t, ":9091", group.ServiceServerAddr)
+	Equals(t, "127.0.0.1:7070", group.DebugServerAddr)
+	Assert(t, group.DisableDebugServer, "WithDisableDebug must set DisableDebugServer")
+	Equals(t, 30*time.Second, group.ServiceWriteTimeout, "options must leave other defaults alone")
+}
@@ -0,0 +1,69 @@
+package servicegroup
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// NewPipeListener returns a listener backed by in-memory pipes, for use as a Group's ServiceListener, along with an
+// http.Client whose connections are dialed to it. Whatever host a request names, it's served by the Group, which
+// makes for fast socket-free tests of the whole service stack, middleware and shutdown included.
+func NewPipeListener() (net.Listener, *http.Client) {
+	l := &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return l.dial(ctx)
+		},
+	}}
+	return l, client
+}
+
+// pipeListener is a net.Listener whose connections are the server ends of net.Pipes created by dial.
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// dial hands the server end of a new pipe to Accept and returns the client end.
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+	case <-ctx.Done():
+		server.Close()
+		client.Close()
+		return nil, ctx.Err()
+	}
+	server.Close()
+	client.Close()
+	return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr{}, Err: net.ErrClosed}
+}
+
+// pipeAddr is the address of every pipeListener.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
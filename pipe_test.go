@@ -0,0 +1,38 @@
+package servicegroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+func TestPipeListener_ServesGroupWithoutSockets(t *testing.T) {
+	// * Run a group on an in-memory listener with the debug server off, so no ports are bound
+	// * Validate a request through the paired client reaches the handler, then that the group shuts down cleanly
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello over %s", r.Context().Value(http.LocalAddrContextKey))
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+
+	WaitForURL(t, client, "http://servicegroup/")
+	resp, err := client.Get("http://servicegroup/")
+	Ok(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Ok(t, err)
+	Equals(t, "hello over pipe", string(body))
+	client.CloseIdleConnections()
+
+	group.Signal(syscall.SIGINT)
+	Assert(t, (<-done) != nil, "Run must return the signal that stopped it")
+
+	_, err = client.Get("http://servicegroup/")
+	Assert(t, err != nil, "requests must fail once the group has shut down")
+}
@@ -0,0 +1,199 @@
+package servicegroup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// errProxyProtocolHeader is returned by reads from a connection that didn't start with a valid PROXY protocol header.
+var errProxyProtocolHeader = errors.New("servicegroup: missing or malformed PROXY protocol header")
+
+// proxyProtocolHeaderTimeout bounds reading the PROXY protocol header when the service server has no read timeouts
+// of its own.
+const proxyProtocolHeaderTimeout = 10 * time.Second
+
+// proxyProtocolListener accepts connections that start with a PROXY protocol (v1 or v2) header, as sent by load
+// balancers like AWS NLBs, reporting the client address it carries as the connections' RemoteAddr once it's read.
+type proxyProtocolListener struct {
+	net.Listener
+	timeout time.Duration // deadline for reading the header; 0 for proxyProtocolHeaderTimeout
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+// proxyProtocolConn reads its PROXY protocol header on first Read, from the server's goroutine for the connection
+// rather than the accept loop, so a slow client can't hold up the others. Until then RemoteAddr is the socket's.
+type proxyProtocolConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	parsed int32 // set once the header has been read, after which remote and err don't change
+	r      *bufio.Reader
+	remote net.Addr // the client address from the header; nil to keep the connection's own
+	err    error    // why the header couldn't be read
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			// the server set about the same deadline before reading, and resets its own once the request head is in
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		} else {
+			c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyProtocolHeader(c.r)
+		if _, ok := c.err.(*net.OpError); c.err != nil && !ok {
+			// as a read error, the server drops the connection rather than answering 400
+			c.err = &net.OpError{Op: "read", Net: c.Conn.LocalAddr().Network(), Source: c.Conn.LocalAddr(),
+				Addr: c.Conn.RemoteAddr(), Err: c.err}
+		}
+		atomic.StoreInt32(&c.parsed, 1)
+	})
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr never blocks: it's the header's client address once the header has been read, and the socket's until
+// then, or if the header carries none.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if atomic.LoadInt32(&c.parsed) == 0 || c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// proxyProtocolConnKey is the context key the service server's connections' *proxyProtocolConn is stored under.
+type proxyProtocolConnKey struct{}
+
+// proxyProtocolConnContext wraps the service server's ConnContext, next if any, to store each connection for
+// proxyProtocolClient.
+func proxyProtocolConnContext(next func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		if tlsConn, ok := c.(*tls.Conn); ok {
+			c = tlsConn.NetConn()
+		}
+		if conn, ok := c.(*proxyProtocolConn); ok {
+			ctx = context.WithValue(ctx, proxyProtocolConnKey{}, conn)
+		}
+		return ctx
+	}
+}
+
+// proxyProtocolClient sets each request's RemoteAddr to the client address from its connection's PROXY protocol
+// header. The server takes RemoteAddr from the connection before its first read, so before the header is read.
+func proxyProtocolClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, ok := r.Context().Value(proxyProtocolConnKey{}).(*proxyProtocolConn); ok {
+			if remote := conn.RemoteAddr().String(); remote != r.RemoteAddr {
+				proxied := *r
+				proxied.RemoteAddr = remote
+				r = &proxied
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readProxyProtocolHeader reads a v1 or v2 PROXY protocol header from r, returning the client address it carries, or
+// nil if it carries none (eg a load balancer's own health checks).
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	if start, err := r.Peek(5); err != nil {
+		return nil, err
+	} else if string(start) == "PROXY" {
+		return readProxyProtocolV1(r)
+	}
+	if start, err := r.Peek(len(proxyProtocolV2Signature)); err != nil {
+		return nil, err
+	} else if bytes.Equal(start, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	}
+	return nil, errProxyProtocolHeader
+}
+
+// readProxyProtocolV1 reads a human-readable header, eg "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n".
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyProtocolHeader
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyProtocolHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyProtocolHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2 reads a binary header: the signature, version and command, address family, length and then the
+// addresses.
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	versionCommand, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if versionCommand>>4 != 2 {
+		return nil, errProxyProtocolHeader
+	}
+	switch versionCommand & 0xf {
+	case 0: // LOCAL: the load balancer's own connection
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errProxyProtocolHeader
+	}
+	switch {
+	case family == 0x11 && len(body) >= 12: // TCP over IPv4
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case family == 0x21 && len(body) >= 36: // TCP over IPv6
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil // other families (UDP, unix sockets) aren't client addresses worth reporting
+}
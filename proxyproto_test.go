@@ -0,0 +1,87 @@
+package servicegroup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewWorkgroup_ProxyProtocol(t *testing.T) {
+	// * Run a group with proxy protocol enabled, echoing each request's RemoteAddr
+	// * Validate v1 and v2 headers set RemoteAddr to the client address they carry, and connections without one are dropped
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.EnableProxyProtocol = true
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	get := func(header []byte) (string, error) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Ok(t, err)
+		defer conn.Close()
+		conn.Write(header)
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: service\r\nConnection: close\r\n\r\n")
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n]), nil
+	}
+
+	remote, err := get([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"))
+	Ok(t, err)
+	Equals(t, "203.0.113.7:51234", remote, "v1 header must set RemoteAddr")
+
+	v2 := append([]byte(nil), proxyProtocolV2Signature...)
+	v2 = append(v2, 0x21, 0x21, 0, 36) // v2 PROXY, TCP over IPv6, 36 bytes of addresses
+	v2 = append(v2, net.ParseIP("2001:db8::7")...)
+	v2 = append(v2, net.ParseIP("2001:db8::1")...)
+	v2 = binary.BigEndian.AppendUint16(v2, 51234)
+	v2 = binary.BigEndian.AppendUint16(v2, 443)
+	remote, err = get(v2)
+	Ok(t, err)
+	Equals(t, "[2001:db8::7]:51234", remote, "v2 header must set RemoteAddr")
+
+	_, err = get(nil)
+	Assert(t, err != nil, "connections without a proxy protocol header must be dropped")
+
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestProxyProtocolConn_RemoteAddrDoesNotBlock(t *testing.T) {
+	// * Accept a proxy protocol connection whose client never sends a header
+	// * Validate RemoteAddr returns the socket's address straight away, and reading gives up at the header deadline
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &proxyProtocolConn{Conn: server, timeout: 50 * time.Millisecond}
+	defer conn.Close()
+
+	addr := make(chan net.Addr, 1)
+	go func() { addr <- conn.RemoteAddr() }()
+	select {
+	case remote := <-addr:
+		Equals(t, server.RemoteAddr(), remote)
+	case <-time.After(time.Second):
+		Assert(t, false, "RemoteAddr must not wait for the header")
+	}
+
+	_, err := conn.Read(make([]byte, 1))
+	Assert(t, err != nil, "a connection whose header never arrives must fail to read")
+	Equals(t, server.RemoteAddr(), conn.RemoteAddr(), "without a header RemoteAddr stays the socket's")
+}
@@ -0,0 +1,128 @@
+package servicegroup
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// addedServer is a server registered with AddServer or AddNamedServer.
+type addedServer struct {
+	name    string // the name it was added with; "" for AddServer
+	addr    string
+	handler http.Handler
+}
+
+// component is the server's ServerError.Component: its name, or "added" and its configured address.
+func (a addedServer) component() string {
+	if a.name != "" {
+		return a.name
+	}
+	return "added " + a.addr
+}
+
+// boundServer is an added server whose listener Run has bound.
+type boundServer struct {
+	server   *http.Server
+	listener net.Listener
+	conns    *connTracker
+	added    addedServer
+}
+
+// AddServer registers another service-style HTTP server, serving handler on addr with the service server's
+// timeouts and keep-alive setting, eg an internal admin API next to the public one. It's bound along with the
+// Group's own servers when Run is called, and shut down gracefully in the same shutdown cascade. The service
+// server's middleware isn't applied to it. Call it before Run.
+func (g *Group) AddServer(addr string, handler http.Handler) {
+	state := g.st()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.servers = append(state.servers, addedServer{addr: addr, handler: handler})
+}
+
+// AddNamedServer is AddServer for a server that's named in its logs and in the ServerError Run returns if it fails,
+// eg "admin", so it's clear which of several added servers that was.
+func (g *Group) AddNamedServer(name, addr string, handler http.Handler) {
+	state := g.st()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.servers = append(state.servers, addedServer{name: name, addr: addr, handler: handler})
+}
+
+// listenAddedServers creates the servers registered with AddServer and binds their listeners. If any fails to bind,
+// the listeners bound so far are closed.
+func (g *Group) listenAddedServers() ([]boundServer, error) {
+	g.state.mu.Lock()
+	added := append([]addedServer(nil), g.state.servers...)
+	g.state.mu.Unlock()
+
+	bound := make([]boundServer, 0, len(added))
+	for _, a := range added {
+		listener, err := net.Listen("tcp", a.addr)
+		if err != nil {
+			for _, b := range bound {
+				b.listener.Close()
+			}
+			return nil, &ServerError{Component: a.component(), Addr: a.addr, Err: err, Bind: true}
+		}
+		conns := newConnTracker()
+		conns.listening(listener.Addr().String())
+		server := &http.Server{
+			Addr:              a.addr,
+			Handler:           a.handler,
+			ReadHeaderTimeout: g.ServiceReadHeaderTimeout,
+			ReadTimeout:       g.ServiceReadTimeout,
+			WriteTimeout:      g.ServiceWriteTimeout,
+			IdleTimeout:       g.ServiceIdleTimeout,
+			MaxHeaderBytes:    g.ServiceMaxHeaderBytes,
+			ConnState:         g.trackAddedConn(conns),
+			ErrorLog:          g.errorLog(),
+		}
+		if g.DisableKeepAlives {
+			server.SetKeepAlivesEnabled(false)
+		}
+		bound = append(bound, boundServer{server: server, listener: listener, conns: conns, added: a})
+	}
+	trackers := make([]*connTracker, len(bound))
+	for i, b := range bound {
+		trackers[i] = b.conns
+	}
+	g.state.mu.Lock()
+	g.state.addedConns = trackers
+	g.state.mu.Unlock()
+	return bound, nil
+}
+
+// addServerWorkers adds the serve and shutdown workers for each of the bound added servers.
+func (g *Group) addServerWorkers(servers []boundServer) {
+	for _, b := range servers {
+		b := b
+		addr := b.listener.Addr().String()
+		name := fmt.Sprintf("HTTP server on %s", addr)
+		if b.added.name != "" {
+			name = fmt.Sprintf("%s HTTP server on %s", b.added.name, addr)
+		}
+		component := b.added.component()
+
+		// WORKGROUP WORKER: serve an added server
+		g.add(fmt.Sprintf("server %s", addr), func(stop <-chan struct{}) error {
+			g.logEvent("server.starting", []slog.Attr{slog.String("server", component), slog.String("addr", addr)},
+				"Starting %s", name)
+			return serveError(component, addr, b.server.Serve(b.listener))
+		})
+
+		// WORKGROUP WORKER: gracefully shut down an added server on workgroup termination
+		g.add(fmt.Sprintf("server %s shutdown", addr), func(stop <-chan struct{}) error {
+			<-stop
+			return g.shutdown(&managedServer{
+				Server: b.server,
+				name:   name,
+				conns:  b.conns,
+
+				timeout:          g.serviceShutdownTimeout(),
+				hardDrainTimeout: g.HardDrainTimeout,
+			})
+		})
+	}
+}
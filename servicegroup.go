@@ -18,32 +18,519 @@ package servicegroup
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/heptio/workgroup"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
-	// Wire up pprof endpoints - use a separate HTTP ListenAndServe + port for this and do not wire into the app!
+	// Wire up pprof endpoints - use a separate HTTP server + port for this and do not wire into the app!
 	// Ensure your application server is using a different port and mux than the one we'll expose below for pprof's use.
 	_ "net/http/pprof"
 )
 
+// Replaceable in tests.
+var (
+	getppid            = os.Getppid
+	parentPollInterval = time.Second
+	randInt63n         = rand.Int63n
+)
+
 // Group is a workgroup.Group that includes some server-specific configuration values. It should be constructed
 // via NewGroup().
 type Group struct {
 	workgroup.Group
 	Handler                  http.Handler  // Handler for service HTTP server
 	DebugServerAddr          string        // Port for default debug server to listen on (default ":6060")
+	DebugNetwork             string        // Network for the debug server to listen on: "tcp", "tcp4" or "tcp6" (default "tcp")
 	ServiceServerAddr        string        // Port for service server (handler passed to NewGroup) to listen on (default ":8080")
+	ServiceNetwork           string        // Network for the service server to listen on: "tcp", "tcp4", "tcp6", or "unix" for a socket path in ServiceServerAddr (default "tcp")
 	ShutdownTimeout          time.Duration // Deadline for HTTP server graceful shutdown when interrupt is sent or any worker in the Group dies
+	DebugShutdownTimeout     time.Duration // Deadline for the debug server's graceful shutdown, eg cutting off profile downloads (default 5 seconds)
 	ServiceReadHeaderTimeout time.Duration // HTTP service header read timeout (default 30 seconds). http.Server.ReadHeaderTimeout: https://golang.org/pkg/net/http/#Server
+	ServiceReadTimeout       time.Duration // HTTP timeout for reading a whole request, body included, from its start; cut slow uploads off before ServiceWriteTimeout (counted from the same start) would (default 0, unlimited). http.Server.ReadTimeout: https://golang.org/pkg/net/http/#Server
 	ServiceWriteTimeout      time.Duration // HTTP timeout for all post-header-read handling, including reading body and writing response (default 30 seconds). http.Server.WriteTimeout: https://golang.org/pkg/net/http/#Server
 	ServiceIdleTimeout       time.Duration // HTTP connection idle timeout (default 30 seconds). http.Server.IdleTimeout: https://golang.org/pkg/net/http/#Server
+	ServiceMaxHeaderBytes    int           // Limit on the size of request headers, request line included; larger ones get 431 (default 0, the stdlib's 1MB). http.Server.MaxHeaderBytes: https://golang.org/pkg/net/http/#Server
+	DisablePprof             bool          // Hide the /debug/pprof endpoints on the debug server (default false)
+	DisableDebugServer       bool          // Don't start the debug server at all (default false)
+	DisableSignalWatcher     bool          // Don't watch for OS interrupt signals; shutdown must be triggered by a worker ending (default false)
+	DisableKeepAlives        bool          // Disable HTTP keep-alives on the service server from the start of Run; see SetKeepAlivesEnabled to toggle them while it runs (default false)
+	RecoverPanics            bool          // Respond 500 and log the stack when the service handler panics, instead of dropping the connection (default false)
+	DisableReadyz            bool          // Don't serve the /readyz readiness endpoint on the debug server (default false)
+	SignalIsCleanExit        bool          // Return nil from Run when one of the ShutdownSignals stopped the Group, rather than an error naming the signal (default false)
+	ForceOnSecondSignal      bool          // Hard close the servers, rather than waiting out ShutdownTimeout, if a second of the ShutdownSignals arrives mid-shutdown (default true)
+	ShutdownSignals          []os.Signal   // OS signals that begin a graceful shutdown; none means only workers can (default SIGINT, SIGTERM)
+
+	// DebugHandler, when set, replaces the default ServeMux as the debug server's handler, isolating it from
+	// whatever other packages register there. If it's a *http.ServeMux without its own /debug/pprof/ handler the pprof
+	// endpoints are still served alongside it, unless DisablePprof is set; for other handlers wire in net/http/pprof's
+	// handlers yourself if you want them (default nil).
+	DebugHandler http.Handler
+
+	// DebugBasicAuth, when set, requires these HTTP Basic Auth credentials for the debug server's /debug/ endpoints
+	// (pprof, state, config and the rest), leaving its other routes, eg the /healthz and /readyz probes, open
+	// (default nil, no auth). The debug server should still never be exposed publicly.
+	DebugBasicAuth *BasicAuth
+
+	// DebugUsername and DebugPassword are shorthand for DebugBasicAuth, taking effect when both are set and
+	// DebugBasicAuth isn't.
+	DebugUsername string
+	DebugPassword string
+
+	// ServiceTLSNextProto is passed through to the service server's http.Server.TLSNextProto, taking over connections
+	// whose ALPN-negotiated protocol matches a key. Its protocols are offered over ALPN after the TLSConfig's own, and
+	// HTTP/2 is still served unless it has an "h2" key (default nil, the stdlib behavior).
+	// https://golang.org/pkg/net/http/#Server
+	ServiceTLSNextProto map[string]func(*http.Server, *tls.Conn, http.Handler)
+
+	// ConfigureServiceServer and ConfigureDebugServer, when set, are called with the service and debug servers' fully
+	// configured http.Servers each time Run creates them, before they're bound or served, to set fields the Group
+	// doesn't surface. Whatever they change wins; keep the Handler and ConnState the Group set (wrap them, if need be)
+	// or its middleware and connection tracking stop working.
+	ConfigureServiceServer func(server *http.Server)
+	ConfigureDebugServer   func(server *http.Server)
+
+	// EnableH2C serves HTTP/2 over cleartext (h2c, both prior knowledge and Upgrade) on the service server alongside
+	// HTTP/1, eg for gRPC clients that can't do TLS. Graceful shutdown sends h2c connections a GOAWAY and waits for
+	// their in-flight requests within the usual timeouts (default false).
+	EnableH2C bool
+
+	// TLSConfig, CertFile and KeyFile make the service server terminate TLS itself, like http.Server.ServeTLS: when
+	// TLSConfig is set or CertFile and KeyFile both are, connections are served over TLS using TLSConfig (if any) and
+	// the certificate and key loaded from the files (if set). CertFile and KeyFile must be set together. Shutdown
+	// works the same as for plaintext (default nil and "", plaintext HTTP).
+	TLSConfig *tls.Config
+	CertFile  string
+	KeyFile   string
+
+	// DebugTLSConfig, DebugCertFile and DebugKeyFile are TLSConfig, CertFile and KeyFile for the debug server, eg where
+	// even internal debug traffic must be encrypted (default nil and "", plaintext HTTP).
+	DebugTLSConfig *tls.Config
+	DebugCertFile  string
+	DebugKeyFile   string
+
+	// RequiredHeaders are headers every service request must carry with exactly these values, eg a shared secret set
+	// by a gateway; requests missing any of them get a 403 (default empty, no enforcement). Paths in
+	// RequiredHeadersSkipPaths (exact matches, eg health checks) are exempt.
+	RequiredHeaders          map[string]string
+	RequiredHeadersSkipPaths []string
+
+	// TrustedProxies are the networks of the proxies (eg a CDN's) in front of the service server. A request from one
+	// of them has its RemoteAddr replaced with the client IP from X-Forwarded-For: the right-most entry that isn't
+	// itself a trusted proxy, so clients can't spoof it by sending their own header. It's replaced before any other
+	// middleware, so the access log, metrics and handler all see the client (default empty, RemoteAddr is the peer's).
+	TrustedProxies []net.IPNet
+
+	// TrustLeftmostForwardedFor makes TrustedProxies take the left-most X-Forwarded-For entry that isn't a trusted
+	// proxy instead, for proxies that replace rather than append to the header they're sent; with ones that append,
+	// clients can spoof that entry (default false, the right-most).
+	TrustLeftmostForwardedFor bool
+
+	// ResponseHeaders are set on service responses once a request has passed the MaxURILength, MaxHeaderCount and
+	// GlobalBodyBudget checks, whose rejections don't carry them. That's ahead of the RequiredHeaders check,
+	// RequestTimeout, MaxConcurrentRequests, RecoverPanics and the Use middleware, so their responses (eg a 503 for a
+	// timeout or a 500 for a recovered panic) do, and handlers can still override them (default empty). Eg
+	// {"X-Content-Type-Options": "nosniff"}.
+	ResponseHeaders map[string]string
+
+	// MaxConcurrentRequests, when positive, caps how many requests the service handler serves at once, to protect
+	// whatever's downstream of it: requests past the cap get a 503 with a Retry-After header straight away rather
+	// than queueing (default 0, unlimited).
+	MaxConcurrentRequests int
+
+	// RequestTimeout, when set, bounds how long the service handler gets for each request, with http.TimeoutHandler:
+	// past it the client gets a 503 with RequestTimeoutMessage as the body (default the stdlib's "Timeout" page) and
+	// the request's context is cancelled. Unlike ServiceWriteTimeout it leaves the connection usable for keep-alive.
+	// Handlers can't hijack connections or flush partial responses while it's set.
+	RequestTimeout        time.Duration
+	RequestTimeoutMessage string
+
+	// HandlerReloader, when set, is called whenever the process receives SIGHUP; the handler it returns atomically
+	// replaces the service handler for subsequent requests. On error the current handler is kept.
+	HandlerReloader func() (http.Handler, error)
+
+	// DiagnosticSignals, when set, are watched for to capture diagnostics on demand without disrupting the service,
+	// eg []os.Signal{syscall.SIGUSR1}. On each one OnDiagnosticSignal is called if set; otherwise goroutine stacks and
+	// memory stats are written to DiagnosticWriter (default os.Stderr). Off by default.
+	DiagnosticSignals  []os.Signal
+	OnDiagnosticSignal func()
+	DiagnosticWriter   io.Writer
+
+	// EnableStateEndpoint serves a JSON report of the Group's configuration and runtime state (phase, uptime, bytes
+	// served, requests in flight, connections and workers) at /debug/servicegroup on the debug server (default false).
+	EnableStateEndpoint bool
+
+	// EnableConfigEndpoint serves the Group's effective configuration as JSON at /debug/config on the debug server
+	// (default false). Secrets such as required header values are never included.
+	EnableConfigEndpoint bool
+
+	// EnableAccessLog logs a line for each completed service request to the Logger, in AccessLogFormat (default
+	// AccessLogCommon). With a SlogHandler each request is instead an "http.request" event carrying the entry's fields.
+	EnableAccessLog bool
+	AccessLogFormat AccessLogFormat
+
+	// SuppressClientDisconnectLogs drops the access log lines of requests whose client went away before the response
+	// finished (their context was cancelled, or writing failed with EPIPE or ECONNRESET), and the servers' ErrorLog
+	// lines about broken pipes and reset connections, which are noise for clients that routinely hang up early
+	// (default false; common and combined lines of such requests end in "client_disconnect" instead).
+	SuppressClientDisconnectLogs bool
+
+	// AccessLogBufferSize, when positive, keeps this many of the most recent service requests in memory and serves
+	// them as JSON at /debug/requests on the debug server (default 0, disabled).
+	AccessLogBufferSize int
+
+	// EnableMetrics serves Prometheus metrics for the service server at /metrics on the debug server (default false):
+	// request counts by route and status code, request durations by route and the number of requests in flight. The
+	// route is the matching pattern when Handler is a *http.ServeMux, and empty otherwise. Like the probes, /metrics
+	// is served without DebugBasicAuth's credentials, so scrapers don't need them.
+	EnableMetrics bool
+
+	// HealthCheckTimeout is how long each check registered with RegisterHealthCheck gets to finish when /healthz is
+	// requested before it counts as failing (default 1 second).
+	HealthCheckTimeout time.Duration
+
+	// EnablePreStopEndpoint serves POST /debug/prestop on the debug server for Kubernetes preStop hooks: it marks the
+	// Group not ready, then waits PreStopDelay (if 0, the PreShutdownDelay) before responding 200, giving load
+	// balancers time to deregister the instance before the SIGTERM that follows starts the actual shutdown. The
+	// delays add up: the SIGTERM's shutdown still waits out any PreShutdownDelay, and Kubernetes counts both against
+	// the pod's terminationGracePeriodSeconds (default false, 0).
+	EnablePreStopEndpoint bool
+	PreStopDelay          time.Duration
+
+	// ReadyFilePath, when set, is a file the Group creates once its servers are listening and removes as soon as
+	// shutdown begins (or when Run returns, whichever is first), for orchestration that watches the filesystem.
+	ReadyFilePath string
+
+	// MaxRequests, when positive, makes the Group gracefully shut itself down once the service server has completed
+	// this many requests; useful for canaries and soak tests of the drain path (default 0, unlimited).
+	MaxRequests int64
+
+	// GlobalBodyBudget, when positive, caps the total request body bytes read across all in-flight service requests.
+	// New requests are shed with 503 Service Unavailable while the budget is exhausted, and a body read that would
+	// exceed it fails; a request's bytes are released when it completes (default 0, unlimited).
+	GlobalBodyBudget int64
+
+	// MaxURILength and MaxHeaderCount, when positive, reject service requests whose request URI is longer than
+	// MaxURILength bytes with 414 URI Too Long, and those with more than MaxHeaderCount header fields with 431 Request
+	// Header Fields Too Large (default 0, no limit). The check comes after TrustedProxies and the request
+	// instrumentation, so rejections are counted, logged and measured with the client's address like any other
+	// request, but ahead of the rest of the middleware and the handler. They complement http.Server's overall header
+	// size limit.
+	MaxURILength   int
+	MaxHeaderCount int
+
+	// SoftDrainTimeout, when set, replaces ShutdownTimeout as the graceful shutdown deadline. If requests are still
+	// in flight when it expires and HardDrainTimeout is set, that's logged and they get HardDrainTimeout longer to
+	// finish before the server is forcibly closed (default 0 for both, a single ShutdownTimeout deadline).
+	SoftDrainTimeout time.Duration
+	HardDrainTimeout time.Duration
+
+	// PreShutdownDelay, when set, is how long the servers keep accepting and serving requests normally once shutdown
+	// has begun, before their graceful shutdown starts; time for load balancers (eg a Kubernetes Service) to stop
+	// routing to the instance. Readiness is withdrawn as soon as shutdown begins, and the ShutdownTimeout (or drain
+	// timeouts) only start counting once the delay is over (default 0).
+	PreShutdownDelay time.Duration
+
+	// OnReady, when set, is called once Run has bound all of its servers' listeners, so connections are being
+	// accepted; eg to register the instance with service discovery. The servers start serving once it returns, with
+	// connections queueing until then. See also Ready.
+	OnReady func()
+
+	// OnShutdown, when set, is called once at the very start of the shutdown cascade, before any server begins shutting
+	// down, with a context that times out after ShutdownTimeout; eg to flush metrics or close a producer. An error is
+	// logged and shutdown carries on.
+	OnShutdown func(ctx context.Context) error
+
+	// PostDrainHold, when set, is how long Run waits after every server and worker has finished before returning,
+	// giving sidecars (log shippers, metrics agents) a moment to flush the final telemetry (default 0).
+	PostDrainHold time.Duration
+
+	// Logger receives the Group's lifecycle messages (default, and when nil, the standard log package's logger).
+	Logger Logger
+
+	// SlogHandler, when set, receives the Group's main lifecycle events as structured log/slog records instead of
+	// them going to the Logger as text: "server.starting" (server, addr), "signal.received" (signal),
+	// "server.shutdown.graceful" and "server.shutdown.hard" (server, elapsed, force_closed). Other messages still go
+	// to the Logger (default nil).
+	SlogHandler slog.Handler
+
+	// LoggerFlush, when set, is called as the very last step of Run, after shutdown has completed and been logged,
+	// so buffered or asynchronous loggers deliver the final shutdown messages before the process exits. If it isn't
+	// set and the Logger has a Flush() error method, that's called instead.
+	LoggerFlush func() error
+
+	// ExtractTraceContext, when set, is called for every service request and the context it returns replaces the
+	// request's context, eg to attach a trace parsed from traceparent or X-B3-* headers with the tracing library of
+	// your choice. The returned context should be derived from r.Context().
+	ExtractTraceContext func(r *http.Request) context.Context
+
+	// ShutdownOnParentDeath makes the Group gracefully shut down if its parent process exits, rather than carrying on
+	// as an orphan; the parent PID is polled every second (default false).
+	ShutdownOnParentDeath bool
+
+	// OnConnClose, when set, is called whenever a service server connection closes, with the client's address and
+	// how long the connection was open; useful for debugging connection churn. Hijacked connections aren't reported.
+	OnConnClose func(remoteAddr string, lifetime time.Duration)
+
+	// OnActiveConnsChange, when set, is called with a listener's address and its new count of open connections
+	// whenever a connection on the service or debug server, or one added with AddServer, opens, closes or is
+	// hijacked; for pushing the ActiveConnections gauge to a metrics system. It's called on the connection's
+	// goroutine, so it must be fast.
+	OnActiveConnsChange func(listenerAddr string, active int64)
+
+	// ServiceConnState, when set, is called on every service server connection state change, like
+	// http.Server.ConnState; the Group's own connection tracking keeps working alongside it (default nil).
+	ServiceConnState func(net.Conn, http.ConnState)
+
+	// ServiceBaseContext, when set, is the service server's http.Server.BaseContext: it returns the context every
+	// request's context derives from, eg seeded with a logger or trace root. The Group never cancels it, so requests
+	// still draining during graceful shutdown keep a live context (default nil, context.Background()).
+	ServiceBaseContext func(net.Listener) context.Context
+
+	// ServiceConnContext, when set, is the service server's http.Server.ConnContext: it derives each connection's
+	// context, eg to attach the peer's TLS certificate details for mTLS-based auth (default nil).
+	ServiceConnContext func(ctx context.Context, c net.Conn) context.Context
+
+	// MaintenanceExemptPaths are service paths (exact matches, eg health checks) that keep going to the service
+	// handler while the Group is in maintenance mode; see EnterMaintenance.
+	MaintenanceExemptPaths []string
+
+	// ShutdownJitter, when set, delays the start of shutdown after an OS signal by a random duration up to this
+	// long, spreading out the drains of instances signalled at the same time; the servers keep serving normally
+	// meanwhile. Another signal during the delay cuts it short, starting the graceful shutdown right away; only one
+	// after that forces it (default 0).
+	ShutdownJitter time.Duration
+
+	// ShutdownOrder is the order the service and debug servers shut down in (default ShutdownConcurrent). With
+	// ShutdownServiceFirst the debug server keeps serving until the service server has drained, eg so scrapers
+	// capture the final metrics; ShutdownDebugFirst is the reverse. Servers added with AddServer shut down alongside
+	// the service server.
+	ShutdownOrder ShutdownOrder
+
+	// OnShutdownSummary, when set, is called at the end of Run with a summary of the shutdown (also logged as a
+	// single line).
+	OnShutdownSummary func(summary ShutdownSummary)
+
+	// OnShutdownComplete, when set, is called as each of the Group's HTTP servers finishes shutting down, with how
+	// long its shutdown took and whether it drained gracefully rather than being forcibly closed; eg for recording
+	// shutdown metrics to tune ShutdownTimeout by. It's called from the server's shutdown worker, so the servers'
+	// calls can overlap.
+	OnShutdownComplete func(name string, elapsed time.Duration, graceful bool)
+
+	// Socket options applied to each TCP connection the service server accepts; nil/zero leaves the OS and Go
+	// defaults (Go enables TCP_NODELAY by default). The buffer sizes set SO_RCVBUF and SO_SNDBUF in bytes.
+	ServiceTCPNoDelay      *bool
+	ServiceReadBufferSize  int
+	ServiceWriteBufferSize int
+
+	// ServiceSlowStart, when its InitialRate and RampDuration are set, limits how fast the service server accepts
+	// new connections after Run starts, ramping up to unlimited (default disabled).
+	ServiceSlowStart SlowStart
+
+	// EnableProxyProtocol makes the service server expect every connection to start with a PROXY protocol (v1 or v2)
+	// header, as sent by eg an AWS NLB with proxy protocol enabled, so requests' RemoteAddr is the original client's
+	// rather than the load balancer's. Connections without a valid header are dropped; the header must arrive within
+	// the ServiceReadHeaderTimeout, else the ServiceReadTimeout, else 10s. The debug server is unaffected (default
+	// false).
+	EnableProxyProtocol bool
+
+	// ServiceListener, when set, is served by the service server instead of binding ServiceServerAddr; Run closes
+	// it on shutdown. NewPipeListener returns one backed by in-memory pipes for socket-free tests.
+	ServiceListener net.Listener
+
+	state *groupState // made by NewGroup, or by st for a Group literal
+}
+
+// ShutdownOrder is the order a Group's servers shut down in.
+type ShutdownOrder string
+
+// Shutdown orders.
+const (
+	ShutdownConcurrent   ShutdownOrder = "concurrent"    // all servers at once
+	ShutdownServiceFirst ShutdownOrder = "service-first" // the debug server once the service server has shut down
+	ShutdownDebugFirst   ShutdownOrder = "debug-first"   // the service server once the debug server has shut down
+)
+
+// groupState is the runtime state of a Group. It's allocated by NewGroup and shared by copies of the Group, so it's
+// safe to read from while the Group runs.
+type groupState struct {
+	bytesIn  int64 // request body bytes read by the service handler; accessed atomically
+	bytesOut int64 // response bytes written by the service handler; accessed atomically
+	requests int64 // requests completed by the service handler; accessed atomically
+	inFlight int64 // requests being served by the service handler; accessed atomically
+
+	clientDisconnects int64 // requests whose client went away before the response was finished; accessed atomically
+
+	bodyBytes int64 // request body bytes read by in-flight requests, counted against GlobalBodyBudget; accessed atomically
+
+	unready      int32 // non-zero once the Group has been told to report itself not ready; accessed atomically
+	shuttingDown int32 // non-zero from the start of the shutdown cascade; accessed atomically
+
+	recent  *accessLogBuffer // the most recent service requests if AccessLogBufferSize is set, guarded by mu
+	metrics *httpMetrics     // the service requests' metrics if EnableMetrics is set, guarded by mu
+
+	handler     atomic.Value // handlerRef to the currently-served service handler
+	maintenance atomic.Value // handlerRef to the maintenance handler, or to nil when not in maintenance
+
+	mu      sync.Mutex
+	phase   string    // lifecycle phase, one of the phase constants
+	started time.Time // when Run was last called
+
+	shutdownOnce sync.Once // guards beginShutdown
+
+	trigger chan error    // receives the reason the Group was asked to shut itself down
+	force   chan struct{} // closed to cut the current Run's graceful shutdown short, guarded by mu
+
+	accepting  *gate           // open unless the service listener is paused
+	conns      *connTracker    // the service server's open connections
+	debugConns *connTracker    // the debug server's open connections
+	addedConns []*connTracker  // the open connections of the current Run's added servers, guarded by mu
+	inflight   *requestTracker // the service server's in-flight requests
+
+	signals *signalSubscriptions // the Group's signal watchers
+
+	serviceServer *http.Server // the service server while Run is serving, guarded by mu
+
+	listening chan struct{} // closed once Run has bound the servers' listeners, guarded by mu
+	stopped   chan struct{} // closed once Run has returned, guarded by mu
+
+	workers    []string      // names of the workers added to the Group with Add and co, guarded by mu
+	runWorkers []string      // names of the workers the latest Run added internally, guarded by mu
+	servers    []addedServer // servers registered with AddServer, guarded by mu
+
+	onShutdown   []func()                          // funcs registered with RegisterOnShutdown, guarded by mu
+	postShutdown []func(ctx context.Context) error // funcs registered with AddPostShutdown, guarded by mu
+
+	middleware []func(http.Handler) http.Handler // middleware registered with Use, guarded by mu
+
+	healthChecks []healthCheck // checks served by /healthz, guarded by mu
+
+	shutdownStarted time.Time        // when beginShutdown ran, guarded by mu
+	shutdowns       []ServerShutdown // outcomes of the servers shut down so far, guarded by mu
+}
+
+// newGroupState returns the runtime state for a Group that hasn't started yet.
+func newGroupState() *groupState {
+	s := &groupState{
+		phase:      phaseIdle,
+		trigger:    make(chan error, 1),
+		force:      make(chan struct{}),
+		accepting:  newGate(),
+		conns:      newConnTracker(),
+		debugConns: newConnTracker(),
+		inflight:   newRequestTracker(),
+		signals:    newSignalSubscriptions(),
+		listening:  make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	s.maintenance.Store(handlerRef{})
+	return s
+}
+
+// stateInit guards making the state of Group literals, which don't get one from NewGroup.
+var stateInit sync.Mutex
+
+// st returns the Group's state, making it first if the Group is a literal. Methods that can be called before Run go
+// through it, so a literal Group works as one from NewGroup does.
+func (g *Group) st() *groupState {
+	stateInit.Lock()
+	defer stateInit.Unlock()
+	if g.state == nil {
+		g.state = newGroupState()
+	}
+	return g.state
+}
+
+// reset clears the state left behind by a previous Run, so the Group can run again. Lifetime stats, registrations
+// and the current handler carry over.
+func (s *groupState) reset() {
+	atomic.StoreInt64(&s.requests, 0)
+	atomic.StoreInt32(&s.unready, 0)
+	atomic.StoreInt32(&s.shuttingDown, 0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdownOnce = sync.Once{}
+	s.trigger = make(chan error, 1)
+	s.force = make(chan struct{})
+	s.listening = make(chan struct{})
+	s.stopped = make(chan struct{})
+	s.runWorkers = nil
+	s.addedConns = nil
+	s.shutdownStarted = time.Time{}
+	s.shutdowns = nil
+}
+
+// runChannels returns the channels that follow the current or latest Run.
+func (s *groupState) runChannels() (listening, stopped chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listening, s.stopped
+}
+
+func (s *groupState) setRecentRequests(recent *accessLogBuffer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent = recent
+}
+
+func (s *groupState) recentRequests() *accessLogBuffer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recent
+}
+
+func (s *groupState) setRequestMetrics(metrics *httpMetrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = metrics
+}
+
+func (s *groupState) requestMetrics() *httpMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.metrics
+}
+
+// Lifecycle phases of a Group.
+const (
+	phaseIdle         = "idle"
+	phaseRunning      = "running"
+	phaseShuttingDown = "shutting down"
+	phaseStopped      = "stopped"
+)
+
+// setPhase records the Group's lifecycle phase.
+func (s *groupState) setPhase(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phase
+}
+
+// currentPhase returns the Group's lifecycle phase.
+func (s *groupState) currentPhase() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.phase
+}
+
+// handlerRef boxes an http.Handler so differently-typed handlers can be stored in the same atomic.Value.
+type handlerRef struct {
+	http.Handler
 }
 
 // NewGroup sets up http.Servers configured to use the passed handler on :8080 and debug/metrics on :6060, and an
@@ -52,17 +539,35 @@ type Group struct {
 // Returns a servicegroup.Group that embeds a heptio/workgroup.Group ready to add more workers, or to call .Run().
 //
 // Additional configuration of ports and timeouts can be set *before* .Run is called by setting parameters on the
-// returned Group struct. Workers and http.Servers are only initialized and started after .Run() is called.
-func NewGroup(handler http.Handler) Group {
-	return Group{
+// returned Group struct, or by passing Options. Workers and http.Servers are only initialized and started after
+// .Run() is called.
+func NewGroup(handler http.Handler, opts ...Option) Group {
+	g := Group{
 		Handler:                  handler,
 		ShutdownTimeout:          30 * time.Second,
+		DebugShutdownTimeout:     5 * time.Second,
 		ServiceReadHeaderTimeout: 30 * time.Second,
 		ServiceWriteTimeout:      30 * time.Second,
 		ServiceIdleTimeout:       30 * time.Second,
 		DebugServerAddr:          ":6060",
+		DebugNetwork:             "tcp",
+		ServiceNetwork:           "tcp",
 		ServiceServerAddr:        ":8080",
+		ForceOnSecondSignal:      true,
+		ShutdownSignals:          []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+		Logger:                   stdLogger{},
+		state:                    newGroupState(),
+	}
+	for _, opt := range opts {
+		opt(&g)
 	}
+	return g
+}
+
+// NewGroupWithOptions is NewGroup, for configuring the Group entirely through Options rather than by setting fields
+// on the returned Group.
+func NewGroupWithOptions(handler http.Handler, opts ...Option) Group {
+	return NewGroup(handler, opts...)
 }
 
 // Run starts the http.Servers for debug and the service using the Group's configured ports and timeouts, as
@@ -72,79 +577,918 @@ func NewGroup(handler http.Handler) Group {
 // workers will block until they gracefully shut down the HTTP servers, with a fallback to forcibly closing the servers
 // after the ShutdownTimeout period elapses.
 func (g *Group) Run() error {
-	log.Printf("Service starting")
+	return g.RunContext(context.Background())
+}
+
+// RunContext is Run, but also shuts the Group down gracefully once ctx is done, for stopping it programmatically
+// (eg from a supervisor) without sending the process a signal.
+//
+// A Group can be run again once Run has returned, eg by a supervisor restarting it: the servers are recreated and
+// the workers added with Add and co run again. A ServiceListener is closed by the previous Run, so set a new one.
+func (g *Group) RunContext(ctx context.Context) error {
+	g.st() // the rest of Run, and everything it starts, can use g.state directly
+	if g.state.currentPhase() == phaseStopped {
+		g.state.reset()
+	}
+	// the servers and watchers Run adds to the workgroup are for this run only
+	defer func(workers workgroup.Group) { g.Group = workers }(g.Group)
+	g.state.mu.Lock()
+	g.state.phase = phaseRunning
+	g.state.started = time.Now()
+	stopped := g.state.stopped
+	g.state.mu.Unlock()
+	defer close(stopped)
+	defer g.state.setPhase(phaseStopped)
+	defer g.state.accepting.open() // a pause ends with the Run, so the next one accepts connections
+	g.logf("Service starting")
 	// default handlers go to :6060; for debug-type handlers.
 	debugServer := &http.Server{
-		Addr: g.DebugServerAddr,
+		Addr:    g.DebugServerAddr,
+		Handler: g.debugHandler(),
 		// Timeouts for debug server should be longer, but shouldn't need configurability.
 		ReadHeaderTimeout: 30 * time.Second,
 		WriteTimeout:      300 * time.Second,
 		IdleTimeout:       30 * time.Second,
+		ConnState:         g.trackDebugConn,
+		ErrorLog:          g.errorLog(),
 	}
 
 	// real service handler for :8080
 	serviceServer := &http.Server{
 		Addr:              g.ServiceServerAddr,
-		Handler:           g.Handler,
+		Handler:           g.serviceHandler(),
 		ReadHeaderTimeout: g.ServiceReadHeaderTimeout,
+		ReadTimeout:       g.ServiceReadTimeout,
 		WriteTimeout:      g.ServiceWriteTimeout,
 		IdleTimeout:       g.ServiceIdleTimeout,
+		MaxHeaderBytes:    g.ServiceMaxHeaderBytes,
+		BaseContext:       g.ServiceBaseContext,
+		ConnContext:       g.ServiceConnContext,
+		TLSNextProto:      copyTLSNextProto(g.ServiceTLSNextProto),
+		ConnState:         g.trackConn,
+		ErrorLog:          g.errorLog(),
+	}
+	if g.EnableProxyProtocol {
+		serviceServer.ConnContext = proxyProtocolConnContext(g.ServiceConnContext)
+	}
+	if g.DisableKeepAlives {
+		serviceServer.SetKeepAlivesEnabled(false)
+	}
+	g.state.mu.Lock()
+	for _, f := range g.state.onShutdown {
+		serviceServer.RegisterOnShutdown(f)
+	}
+	g.state.serviceServer = serviceServer
+	g.state.mu.Unlock()
+	defer func() {
+		g.state.mu.Lock()
+		g.state.serviceServer = nil
+		g.state.mu.Unlock()
+	}()
+	switch g.ShutdownOrder {
+	case "", ShutdownConcurrent, ShutdownServiceFirst, ShutdownDebugFirst:
+	default:
+		return fmt.Errorf("unknown ShutdownOrder %q", g.ShutdownOrder)
 	}
 
-	// WORKGROUP WORKER: listen on port 6060 with default mux (pprof handler)
-	// This default server should only be used for debug services and shouldn't be exposed to the public internet
-	g.Add(func(stop <-chan struct{}) error {
-		log.Printf("Starting debug server on %s", g.DebugServerAddr)
-		return debugServer.ListenAndServe()
-	})
+	// Bind the listeners up front so we know we're accepting connections before anything depends on it.
+	if (g.CertFile == "") != (g.KeyFile == "") {
+		return fmt.Errorf("CertFile and KeyFile must both be set to serve TLS (CertFile %q, KeyFile %q)", g.CertFile, g.KeyFile)
+	}
+	serveTLS := g.servesTLS()
+	if serveTLS {
+		serviceServer.TLSConfig = g.TLSConfig.Clone() // HTTP/2 setup mustn't change the caller's
+		if g.ServiceTLSNextProto != nil {
+			if serviceServer.TLSConfig == nil {
+				serviceServer.TLSConfig = &tls.Config{}
+			}
+			// ALPN only negotiates protocols the TLSConfig offers, and net/http doesn't add TLSNextProto's
+			serviceServer.TLSConfig.NextProtos = appendNextProtos(serviceServer.TLSConfig.NextProtos, g.ServiceTLSNextProto)
+		}
+	}
+	if (g.DebugCertFile == "") != (g.DebugKeyFile == "") {
+		return fmt.Errorf("DebugCertFile and DebugKeyFile must both be set to serve TLS (DebugCertFile %q, DebugKeyFile %q)",
+			g.DebugCertFile, g.DebugKeyFile)
+	}
+	debugTLS := g.DebugTLSConfig != nil || g.DebugCertFile != ""
+	if debugTLS {
+		debugServer.TLSConfig = g.DebugTLSConfig
+	}
+	if g.EnableH2C {
+		// configuring the http2.Server on the http.Server makes Shutdown send h2c connections a GOAWAY; it's done
+		// once the TLSConfig is final, since it sets that up for h2 too
+		h2s := &http2.Server{IdleTimeout: g.ServiceIdleTimeout}
+		if err := http2.ConfigureServer(serviceServer, h2s); err != nil {
+			return fmt.Errorf("configuring HTTP/2 for h2c: %s", err)
+		}
+		serviceServer.Handler = h2c.NewHandler(serviceServer.Handler, h2s)
+	} else if _, h2 := g.ServiceTLSNextProto["h2"]; serveTLS && g.ServiceTLSNextProto != nil && !h2 {
+		// net/http only sets up HTTP/2 itself when TLSNextProto is nil
+		if err := http2.ConfigureServer(serviceServer, &http2.Server{IdleTimeout: g.ServiceIdleTimeout}); err != nil {
+			return fmt.Errorf("configuring HTTP/2 alongside ServiceTLSNextProto: %s", err)
+		}
+	}
+	if g.ConfigureServiceServer != nil {
+		g.ConfigureServiceServer(serviceServer)
+	}
+	if g.ConfigureDebugServer != nil {
+		g.ConfigureDebugServer(debugServer)
+	}
 
-	// WORKGROUP WORKER: gracefully shut down debug and service server on workgroup termination
-	g.Add(func(stop <-chan struct{}) error {
-		<-stop
-		return g.shutdown(debugServer, "debug HTTP server")
-	})
+	var err error
+	serviceListener := g.ServiceListener
+	if serviceListener == nil {
+		if serviceListener, err = net.Listen(g.ServiceNetwork, g.ServiceServerAddr); err != nil {
+			return &ServerError{Component: "service", Addr: g.ServiceServerAddr, Err: err, Bind: true}
+		}
+		if g.ServiceNetwork == "unix" {
+			// closing the listener normally unlinks the socket, but make sure it's gone whatever happens on the way
+			defer g.removeSocket(g.ServiceServerAddr)
+		}
+	}
+	if g.ServiceTCPNoDelay != nil || g.ServiceReadBufferSize > 0 || g.ServiceWriteBufferSize > 0 {
+		serviceListener = &sockoptListener{
+			Listener:        serviceListener,
+			noDelay:         g.ServiceTCPNoDelay,
+			readBufferSize:  g.ServiceReadBufferSize,
+			writeBufferSize: g.ServiceWriteBufferSize,
+			logf:            g.logf,
+		}
+	}
+	if g.ServiceSlowStart.enabled() {
+		serviceListener = newSlowStartListener(serviceListener, g.ServiceSlowStart)
+	}
+	if g.EnableProxyProtocol {
+		timeout := g.ServiceReadHeaderTimeout
+		if timeout == 0 {
+			timeout = g.ServiceReadTimeout
+		}
+		serviceListener = &proxyProtocolListener{Listener: serviceListener, timeout: timeout}
+	}
+	serviceListener = newPausableListener(serviceListener, g.state.accepting)
+	g.state.conns.listening(serviceListener.Addr().String())
+	var debugListener net.Listener
+	if !g.DisableDebugServer {
+		debugListener, err = net.Listen(g.DebugNetwork, g.DebugServerAddr)
+		if err != nil {
+			serviceListener.Close()
+			return &ServerError{Component: "debug", Addr: g.DebugServerAddr, Err: err, Bind: true}
+		}
+		g.state.debugConns.listening(debugListener.Addr().String())
+	}
+	addedServers, err := g.listenAddedServers()
+	if err != nil {
+		serviceListener.Close()
+		if debugListener != nil {
+			debugListener.Close()
+		}
+		return err
+	}
+
+	if g.ReadyFilePath != "" {
+		if err := ioutil.WriteFile(g.ReadyFilePath, []byte("ready\n"), 0644); err != nil {
+			g.logf("Error creating ready file %s: %s", g.ReadyFilePath, err)
+		}
+		defer g.removeReadyFile()
+	}
+
+	// closed as each server finishes shutting down, for the other to wait on if the ShutdownOrder says so
+	serviceDown, debugDown := make(chan struct{}), make(chan struct{})
+	if g.DisableDebugServer {
+		close(debugDown)
+	}
+
+	if !g.DisableDebugServer {
+		// WORKGROUP WORKER: listen on port 6060 with default mux (pprof handler)
+		// This default server should only be used for debug services and shouldn't be exposed to the public internet
+		g.add("debug server", func(stop <-chan struct{}) error {
+			attrs := []slog.Attr{slog.String("server", "debug"), slog.String("addr", g.DebugServerAddr), slog.Bool("tls", debugTLS)}
+			if debugTLS {
+				g.logEvent("server.starting", attrs, "Starting debug HTTPS server on %s", g.DebugServerAddr)
+				return serveError("debug", g.DebugServerAddr, debugServer.ServeTLS(debugListener, g.DebugCertFile, g.DebugKeyFile))
+			}
+			g.logEvent("server.starting", attrs, "Starting debug server on %s", g.DebugServerAddr)
+			return serveError("debug", g.DebugServerAddr, debugServer.Serve(debugListener))
+		})
+
+		// WORKGROUP WORKER: gracefully shut down debug and service server on workgroup termination
+		g.add("debug server shutdown", func(stop <-chan struct{}) error {
+			<-stop
+			defer close(debugDown)
+			if g.ShutdownOrder == ShutdownServiceFirst {
+				<-serviceDown
+			}
+			return g.shutdown(&managedServer{
+				Server:  debugServer,
+				name:    "debug HTTP server",
+				conns:   g.state.debugConns,
+				timeout: g.DebugShutdownTimeout,
+			})
+		})
+	}
 
 	// WORKGROUP WORKER: listen on port 8080 for app traffic (using the service's custom handler)
 	// Real service work should happen on this custom handler, not the default debug servemux used at :6060 above.
-	g.Add(func(stop <-chan struct{}) error {
-		log.Printf("Starting service HTTP server on %s", g.ServiceServerAddr)
-		return serviceServer.ListenAndServe()
+	g.add("service server", func(stop <-chan struct{}) error {
+		addr := serviceListener.Addr().String()
+		attrs := []slog.Attr{slog.String("server", "service"), slog.String("addr", addr), slog.Bool("tls", serveTLS)}
+		if serveTLS {
+			g.logEvent("server.starting", attrs, "Starting service HTTPS server on %s", addr)
+			return serveError("service", addr, serviceServer.ServeTLS(serviceListener, g.CertFile, g.KeyFile))
+		}
+		g.logEvent("server.starting", attrs, "Starting service HTTP server on %s", addr)
+		return serveError("service", addr, serviceServer.Serve(serviceListener))
 	})
 
 	// WORKGROUP WORKER: gracefully shut down main service server on workgroup termination
-	g.Add(func(stop <-chan struct{}) error {
+	g.add("service server shutdown", func(stop <-chan struct{}) error {
 		<-stop
-		return g.shutdown(serviceServer, "service HTTP server")
+		defer close(serviceDown)
+		if g.ShutdownOrder == ShutdownDebugFirst {
+			<-debugDown
+		}
+		return g.shutdown(&managedServer{
+			Server:   serviceServer,
+			name:     "service HTTP server",
+			conns:    g.state.conns,
+			requests: g.state.inflight,
+
+			timeout:          g.serviceShutdownTimeout(),
+			hardDrainTimeout: g.HardDrainTimeout,
+			drainHijacked:    g.EnableH2C,
+		})
 	})
 
-	// WORKGROUP WORKER: watch for interrupt/term signals so we can shut down gracefully
-	g.Add(func(stop <-chan struct{}) error {
-		// interrupt/kill signals sent from terminal or host on shutdown
-		interrupt := make(chan os.Signal, 1)
-		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
-		log.Printf("Watching for OS interrupt signals...")
+	g.addServerWorkers(addedServers)
+
+	// forcing is closed once the workers have all returned, ending any force watcher, which Run then waits for
+	forcing := make(chan struct{})
+	var forceWatchers sync.WaitGroup
+	if !g.DisableSignalWatcher {
+		// WORKGROUP WORKER: watch for interrupt/term signals so we can shut down gracefully
+		g.add("signal watcher", func(stop <-chan struct{}) error {
+			// interrupt/kill signals sent from terminal or host on shutdown
+			interrupt := make(chan os.Signal, 1)
+			watching := len(g.ShutdownSignals) > 0 // notifying for no signals at all would relay every signal
+			if watching {
+				g.notify(interrupt, g.ShutdownSignals...)
+				defer func() {
+					if watching {
+						g.stopNotify(interrupt)
+					}
+				}()
+			}
+			g.logf("Watching for OS signals %v...", g.ShutdownSignals)
+			select {
+			case <-stop:
+				return fmt.Errorf("shutting down OS signal watcher on workgroup stop")
+			case i := <-interrupt:
+				if g.ShutdownJitter > 0 {
+					// keep serving for a random moment so a fleet signalled all at once doesn't drain in lockstep
+					jitter := time.Duration(randInt63n(int64(g.ShutdownJitter)))
+					g.logEvent("signal.received", []slog.Attr{slog.String("signal", i.String()), slog.Duration("jitter", jitter)},
+						"Received OS signal %s; beginning shutdown in %s...", i, jitter)
+					timer := time.NewTimer(jitter)
+					select {
+					case <-stop:
+						timer.Stop()
+						return fmt.Errorf("shutting down OS signal watcher on workgroup stop")
+					case again := <-interrupt:
+						// taken here, so only a signal once shutdown has begun forces it
+						timer.Stop()
+						g.logEvent("signal.received", []slog.Attr{slog.String("signal", again.String())},
+							"Received OS signal %s during shutdown jitter; beginning shutdown now...", again)
+					case <-timer.C:
+					}
+				} else {
+					g.logEvent("signal.received", []slog.Attr{slog.String("signal", i.String())},
+						"Received OS signal %s; beginning shutdown...", i)
+				}
+				if g.ForceOnSecondSignal {
+					watching = false // the force watcher takes over the subscription
+					forceWatchers.Add(1)
+					go func() {
+						defer forceWatchers.Done()
+						g.forceOnSignal(interrupt, forcing)
+					}()
+				}
+				return &signalShutdown{sig: i}
+			}
+		})
+	}
+
+	// WORKGROUP WORKER: shut down when the Group triggers its own shutdown (eg MaxRequests reached)
+	g.add("shutdown trigger", func(stop <-chan struct{}) error {
 		select {
 		case <-stop:
-			return fmt.Errorf("shutting down OS signal watcher on workgroup stop")
-		case i := <-interrupt:
-			log.Printf("Received OS signal %s; beginning shutdown...", i)
-			return fmt.Errorf("stopping on OS signal %s", i)
+			return fmt.Errorf("shutting down shutdown trigger watcher on workgroup stop")
+		case err := <-g.state.trigger:
+			g.logf("%s; beginning shutdown...", err)
+			return err
 		}
 	})
 
-	return g.Group.Run()
+	if ctx.Done() != nil {
+		// WORKGROUP WORKER: shut down when the RunContext context is done
+		g.add("context watcher", func(stop <-chan struct{}) error {
+			select {
+			case <-stop:
+				return fmt.Errorf("shutting down context watcher on workgroup stop")
+			case <-ctx.Done():
+				err := fmt.Errorf("stopping on context done: %w", ctx.Err())
+				g.logf("%s; beginning shutdown...", err)
+				return err
+			}
+		})
+	}
+
+	if g.ShutdownOnParentDeath {
+		// WORKGROUP WORKER: watch for our parent process exiting, which reparents us to another process
+		g.add("parent process watcher", func(stop <-chan struct{}) error {
+			parent := getppid()
+			ticker := time.NewTicker(parentPollInterval)
+			defer ticker.Stop()
+			g.logf("Watching parent process %d...", parent)
+			for {
+				select {
+				case <-stop:
+					return fmt.Errorf("shutting down parent process watcher on workgroup stop")
+				case <-ticker.C:
+					if getppid() != parent {
+						g.logf("Parent process %d exited; beginning shutdown...", parent)
+						return fmt.Errorf("stopping on exit of parent process %d", parent)
+					}
+				}
+			}
+		})
+	}
+
+	if g.HandlerReloader != nil {
+		// WORKGROUP WORKER: swap in a reloaded service handler on SIGHUP
+		g.add("handler reloader", func(stop <-chan struct{}) error {
+			hangup := make(chan os.Signal, 1)
+			g.notify(hangup, syscall.SIGHUP)
+			defer g.stopNotify(hangup)
+			for {
+				select {
+				case <-stop:
+					return fmt.Errorf("shutting down handler reloader on workgroup stop")
+				case <-hangup:
+					g.logEvent("signal.received", []slog.Attr{slog.String("signal", syscall.SIGHUP.String())},
+						"Received SIGHUP; reloading service handler...")
+					g.reloadHandler()
+				}
+			}
+		})
+	}
+
+	if len(g.DiagnosticSignals) > 0 {
+		// WORKGROUP WORKER: dump diagnostics on the DiagnosticSignals without shutting down
+		g.add("diagnostics watcher", func(stop <-chan struct{}) error {
+			diagnostic := make(chan os.Signal, 1)
+			g.notify(diagnostic, g.DiagnosticSignals...)
+			defer g.stopNotify(diagnostic)
+			for {
+				select {
+				case <-stop:
+					return fmt.Errorf("shutting down diagnostics watcher on workgroup stop")
+				case sig := <-diagnostic:
+					g.logEvent("signal.received", []slog.Attr{slog.String("signal", sig.String())},
+						"Received OS signal %s; dumping diagnostics...", sig)
+					g.dumpDiagnostics()
+				}
+			}
+		})
+	}
+
+	close(g.state.listening)
+	if g.OnReady != nil {
+		g.OnReady()
+	}
+	err = g.Group.Run()
+	close(forcing)
+	forceWatchers.Wait()
+	g.runPostShutdown()
+	g.summarizeShutdown(err)
+	if g.PostDrainHold > 0 {
+		g.logf("Holding for %s before exit so sidecars can flush", g.PostDrainHold)
+		time.Sleep(g.PostDrainHold)
+	}
+	flush := g.LoggerFlush
+	if f, ok := g.Logger.(interface{ Flush() error }); ok && flush == nil {
+		flush = f.Flush
+	}
+	if flush != nil {
+		if flushErr := flush(); flushErr != nil {
+			// the logger can't be trusted to deliver this, so go straight to stderr
+			fmt.Fprintf(os.Stderr, "Error flushing logger on shutdown: %s\n", flushErr)
+		}
+	}
+	var signalled *signalShutdown
+	if g.SignalIsCleanExit && errors.As(err, &signalled) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully shuts the running Group down, just as a shutdown signal would, and waits for Run to return or
+// ctx to be done, returning ctx's error in that case; Run returns ErrShutdown. It's safe to call concurrently with
+// Run and with itself. Called before Run, the Group shuts down as soon as Run starts; called after Run has returned,
+// it does nothing.
+func (g *Group) Shutdown(ctx context.Context) error {
+	state := g.st()
+	if state.currentPhase() == phaseStopped {
+		return nil
+	}
+	_, stopped := state.runChannels()
+	g.triggerShutdown(ErrShutdown)
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// removeSocket removes a Unix socket file if it still exists, so the next Run can bind the path again.
+func (g *Group) removeSocket(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		g.logf("Error removing socket %s: %s", path, err)
+	}
+}
+
+// Ready returns a channel that's closed once Run has bound all of its servers' listeners, so they're accepting
+// connections. It's never closed if binding fails. Once Run has returned, it follows the next Run.
+func (g *Group) Ready() <-chan struct{} {
+	listening, _ := g.st().runChannels()
+	return listening
+}
+
+// ServiceAddr returns the address the service server's listener is bound to, eg the port picked for ":0". Once Run
+// has been called it blocks until the listener is bound; before Run, or if binding failed, it returns "".
+func (g *Group) ServiceAddr() string {
+	return g.boundAddr(g.st().conns)
+}
+
+// DebugAddr is ServiceAddr for the debug server; it's "" when the debug server is disabled.
+func (g *Group) DebugAddr() string {
+	return g.boundAddr(g.st().debugConns)
+}
+
+// ServiceURL is ServiceAddr as a base URL for requests to the service server, eg "http://127.0.0.1:54321"; it's ""
+// whenever ServiceAddr is.
+func (g *Group) ServiceURL() string {
+	addr := g.ServiceAddr()
+	if addr == "" {
+		return ""
+	}
+	if g.servesTLS() {
+		return "https://" + addr
+	}
+	return "http://" + addr
 }
 
-// Shuts down an HTTP server, using the default timeout. Attempts a graceful shutdown and then a hard close
-// before returning.
-func (g *Group) shutdown(server *http.Server, name string) error {
-	log.Printf("Attempting graceful shutdown of %s on workgroup termination", name)
-	ctx, cancel := context.WithTimeout(context.Background(), g.ShutdownTimeout)
+// servesTLS reports whether the service server terminates TLS itself.
+func (g *Group) servesTLS() bool {
+	return g.TLSConfig != nil || g.CertFile != ""
+}
+
+// boundAddr waits for Run to bind its listeners (or give up) and returns the address t's listener is bound to.
+func (g *Group) boundAddr(t *connTracker) string {
+	if g.state.currentPhase() == phaseIdle {
+		return ""
+	}
+	listening, stopped := g.state.runChannels()
+	select {
+	case <-listening:
+	case <-stopped:
+	}
+	addr, _ := t.gauge()
+	return addr
+}
+
+// SetKeepAlivesEnabled turns HTTP keep-alives on the running service server on or off, eg off during a rolling
+// deploy so clients reconnect and rebalance across instances; while they're off, responses carry "Connection: close".
+// It only affects the current Run: DisableKeepAlives sets whether each Run starts with them off. It has no effect
+// once shutdown has begun, when connections are already closed as their responses finish.
+func (g *Group) SetKeepAlivesEnabled(enabled bool) {
+	state := g.st()
+	state.mu.Lock()
+	server := state.serviceServer
+	state.mu.Unlock()
+	if server == nil {
+		return
+	}
+	server.SetKeepAlivesEnabled(enabled)
+	g.logf("Service server keep-alives enabled: %t", enabled)
+}
+
+// RegisterOnShutdown registers f to be called when the service server starts shutting down, like
+// http.Server.RegisterOnShutdown. Shutdown doesn't close or wait for hijacked connections such as WebSockets, so use
+// it to tell whatever owns them to close them; shutdown then proceeds as they go. Call it before Run.
+func (g *Group) RegisterOnShutdown(f func()) {
+	state := g.st()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.onShutdown = append(state.onShutdown, f)
+}
+
+// Add registers a worker to run alongside the Group's servers, exactly like workgroup.Group.Add; the Group shuts
+// down when any worker returns. It's listed in Workers as "worker-N".
+func (g *Group) Add(fn func(stop <-chan struct{}) error) {
+	state := g.st()
+	state.mu.Lock()
+	name := fmt.Sprintf("worker-%d", len(state.workers)+1)
+	state.mu.Unlock()
+	g.addWorker(name, fn)
+}
+
+// AddOneShot is Add for a worker that runs once and is done, eg a cache warm-up: returning nil leaves the Group
+// running, and only an error brings it down.
+func (g *Group) AddOneShot(fn func(stop <-chan struct{}) error) {
+	g.Add(func(stop <-chan struct{}) error {
+		if err := fn(stop); err != nil {
+			return err
+		}
+		<-stop
+		return nil
+	})
+}
+
+// AddPostShutdown registers fn to run once the Group's HTTP servers have all shut down and its workers have
+// returned, eg to flush metrics that must include the final requests. Its context's deadline is when the
+// ShutdownTimeout, counted from the start of shutdown, runs out. The funcs run one at a time, in the order they were
+// added, before Run returns; their errors are logged.
+func (g *Group) AddPostShutdown(fn func(ctx context.Context) error) {
+	state := g.st()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.postShutdown = append(state.postShutdown, fn)
+}
+
+// runPostShutdown runs the funcs registered with AddPostShutdown.
+func (g *Group) runPostShutdown() {
+	g.state.mu.Lock()
+	funcs := append([]func(ctx context.Context) error(nil), g.state.postShutdown...)
+	started := g.state.shutdownStarted
+	g.state.mu.Unlock()
+	if len(funcs) == 0 {
+		return
+	}
+	if started.IsZero() {
+		started = time.Now()
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), started.Add(g.ShutdownTimeout))
 	defer cancel()
-	err := server.Shutdown(ctx)
+	for _, fn := range funcs {
+		if err := fn(ctx); err != nil {
+			g.logf("Error in post-shutdown func: %s", err)
+		}
+	}
+}
+
+// AddContext is Add for a worker that takes a context rather than a stop channel. The context is cancelled when the
+// Group stops; it never has a deadline. To bound its cleanup to the same window the servers get to drain, the worker
+// can pass it to CleanupContext once it's done.
+func (g *Group) AddContext(fn func(ctx context.Context) error) {
+	g.Add(func(stop <-chan struct{}) error {
+		stopping := &workerStop{timeout: g.ShutdownTimeout}
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), workerStopKey{}, stopping))
+		defer cancel()
+		go func() {
+			select {
+			case <-stop:
+				stopping.stopped(time.Now())
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		return fn(ctx)
+	})
+}
+
+// workerStopKey is the context key an AddContext worker's *workerStop is stored under.
+type workerStopKey struct{}
+
+// workerStop records when an AddContext worker's Group stopped.
+type workerStop struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	at      time.Time
+}
+
+func (s *workerStop) stopped(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.at = at
+}
+
+// CleanupContext returns a context for an AddContext worker's cleanup, given the context the worker was passed: it
+// carries ctx's values but not its cancellation, and its deadline is ShutdownTimeout after the Group stopped (or
+// after now, if it hasn't yet). For any other ctx, it's just ctx with a cancel func.
+func CleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	stopping, ok := ctx.Value(workerStopKey{}).(*workerStop)
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	stopping.mu.Lock()
+	at := stopping.at
+	stopping.mu.Unlock()
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return context.WithDeadline(context.WithoutCancel(ctx), at.Add(stopping.timeout))
+}
+
+// AddNamed is Add for a worker that's listed in Workers under name. Its starting and stopping are logged with the
+// name, and if it fails the error is wrapped in a WorkerError naming it, so it's clear which worker brought the Group
+// down.
+func (g *Group) AddNamed(name string, fn func(stop <-chan struct{}) error) {
+	g.addWorker(name, func(stop <-chan struct{}) error {
+		attrs := []slog.Attr{slog.String("worker", name)}
+		g.logEvent("worker.starting", attrs, "Starting worker %s", name)
+		err := fn(stop)
+		if err != nil {
+			g.logEventLevel(slog.LevelWarn, "worker.stopped", append(attrs, slog.String("error", err.Error())),
+				"Worker %s stopped: %s", name, err)
+			return &WorkerError{Name: name, Err: err}
+		}
+		g.logEvent("worker.stopped", attrs, "Worker %s stopped", name)
+		return nil
+	})
+}
+
+// AddResilient is AddNamed for a worker that's restarted when it fails, eg a queue consumer that should ride out
+// transient errors: it's retried up to maxRetries times, waiting backoff before the first retry and doubling the wait
+// each time after, and only brings the Group down once the retries are used up. A worker that returns nil isn't
+// restarted, and nor is one whose Group stops during the wait.
+func (g *Group) AddResilient(name string, fn func(stop <-chan struct{}) error, maxRetries int, backoff time.Duration) {
+	g.AddNamed(name, func(stop <-chan struct{}) error {
+		for retry := 1; ; retry++ {
+			err := fn(stop)
+			if err == nil || retry > maxRetries {
+				return err
+			}
+			g.logEventLevel(slog.LevelWarn, "worker.restarting", []slog.Attr{
+				slog.String("worker", name),
+				slog.String("error", err.Error()),
+				slog.Int("retry", retry),
+				slog.Duration("backoff", backoff),
+			}, "Worker %s failed, restarting in %s (retry %d of %d): %s", name, backoff, retry, maxRetries, err)
+			if !sleepUnlessStopped(stop, backoff) {
+				return nil
+			}
+			backoff *= 2
+		}
+	})
+}
+
+// addWorker registers a named worker added by the user with the embedded workgroup.
+func (g *Group) addWorker(name string, fn func(stop <-chan struct{}) error) {
+	state := g.st()
+	state.mu.Lock()
+	state.workers = append(state.workers, name)
+	state.mu.Unlock()
+	g.Group.Add(fn)
+}
+
+// add registers a named worker for the current Run with the embedded workgroup.
+func (g *Group) add(name string, fn func(stop <-chan struct{}) error) {
+	g.state.mu.Lock()
+	g.state.runWorkers = append(g.state.runWorkers, name)
+	g.state.mu.Unlock()
+	g.Group.Add(fn)
+}
+
+// Workers returns the names of every worker added to the Group so far, followed by the ones the current or latest
+// Run added internally.
+func (g *Group) Workers() []string {
+	state := g.st()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return append(append([]string(nil), state.workers...), state.runWorkers...)
+}
+
+// reloadHandler replaces the service handler with a fresh one from HandlerReloader, keeping the current handler if
+// the reload fails.
+func (g *Group) reloadHandler() {
+	handler, err := g.HandlerReloader()
 	if err != nil {
-		log.Printf("Error on graceful shutdown of %s: %s", name, err)
-		log.Printf("Attempting hard shutdown of %s", name)
+		g.logf("Error reloading service handler, keeping current handler: %s", err)
+		return
+	}
+	g.state.handler.Store(handlerRef{handler})
+	g.logf("Service handler reloaded")
+}
+
+// sleepUnlessStopped waits for d, returning false early if stop is closed first.
+func sleepUnlessStopped(stop <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// forceOnSignal hard closes the servers if another signal arrives on interrupt before done is closed, then
+// unsubscribes it.
+func (g *Group) forceOnSignal(interrupt chan os.Signal, done <-chan struct{}) {
+	defer g.stopNotify(interrupt)
+	select {
+	case <-done:
+	case i := <-interrupt:
+		g.logEventLevel(slog.LevelWarn, "signal.force", []slog.Attr{slog.String("signal", i.String())},
+			"Received OS signal %s again; hard closing servers...", i)
+		g.state.forceShutdown()
+	}
+}
+
+// forceShutdown cuts the current Run's graceful shutdown short, so its servers are hard closed.
+func (s *groupState) forceShutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.force:
+	default:
+		close(s.force)
+	}
+}
+
+// forced returns the channel closed when the current Run's graceful shutdown is cut short.
+func (s *groupState) forced() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.force
+}
+
+// tlsNextProto is the type of http.Server.TLSNextProto.
+type tlsNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler)
+
+// copyTLSNextProto returns a copy of protos, or nil, so the service server's can be added to (eg "h2" for HTTP/2)
+// without changing ServiceTLSNextProto.
+func copyTLSNextProto(protos tlsNextProto) tlsNextProto {
+	if protos == nil {
+		return nil
+	}
+	copied := make(tlsNextProto, len(protos))
+	for proto, fn := range protos {
+		copied[proto] = fn
+	}
+	return copied
+}
+
+// appendNextProtos appends the protocols in protos that aren't in nextProtos yet to it, in order.
+func appendNextProtos(nextProtos []string, protos tlsNextProto) []string {
+	offered := make(map[string]bool, len(nextProtos))
+	for _, proto := range nextProtos {
+		offered[proto] = true
+	}
+	var added []string
+	for proto := range protos {
+		if !offered[proto] {
+			added = append(added, proto)
+		}
+	}
+	sort.Strings(added)
+	return append(nextProtos, added...)
+}
+
+// triggerShutdown asks the running Group to shut down gracefully, with err as the reason Run returns. Only the first
+// trigger's reason is kept.
+func (g *Group) triggerShutdown(err error) {
+	select {
+	case g.state.trigger <- err:
+	default:
+	}
+}
+
+// beginShutdown runs once per Group, when the first server starts shutting down.
+func (g *Group) beginShutdown() {
+	g.state.shutdownOnce.Do(func() {
+		atomic.StoreInt32(&g.state.shuttingDown, 1)
+		g.state.mu.Lock()
+		g.state.phase = phaseShuttingDown
+		g.state.shutdownStarted = time.Now()
+		g.state.mu.Unlock()
+		g.removeReadyFile()
+		if g.OnShutdown != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), g.ShutdownTimeout)
+			defer cancel()
+			if err := g.OnShutdown(ctx); err != nil {
+				g.logf("Error in OnShutdown hook, continuing shutdown: %s", err)
+			}
+		}
+	})
+}
+
+// removeReadyFile removes the ReadyFilePath file if it's configured and still exists.
+func (g *Group) removeReadyFile() {
+	if g.ReadyFilePath == "" {
+		return
+	}
+	if err := os.Remove(g.ReadyFilePath); err != nil && !os.IsNotExist(err) {
+		g.logf("Error removing ready file %s: %s", g.ReadyFilePath, err)
+	}
+}
+
+// managedServer is one of the Group's HTTP servers, along with what the Group tracks about it.
+type managedServer struct {
+	*http.Server
+	name     string          // for logs, eg "service HTTP server"
+	conns    *connTracker    // the server's open connections
+	requests *requestTracker // the server's in-flight requests, if tracked
+
+	timeout          time.Duration // graceful shutdown deadline
+	hardDrainTimeout time.Duration // extra time to drain once timeout expires, if any
+
+	// drainHijacked is set when requests can outlive http.Server.Shutdown on hijacked connections (eg h2c), so the
+	// tracked requests are waited for separately.
+	drainHijacked bool
+}
+
+// drain gracefully shuts the server down within timeout, giving up early if force is closed. If drainHijacked is
+// set, it then waits out what's left of the timeout for the tracked in-flight requests to finish.
+func (server *managedServer) drain(timeout time.Duration, force <-chan struct{}) error {
+	deadline := time.Now().Add(timeout)
+	if err := shutdownWithin(server.Server, timeout, force); err != nil || !server.drainHijacked {
+		return err
+	}
+	return server.requests.wait(deadline, force)
+}
+
+// serviceShutdownTimeout is the service server's graceful shutdown deadline: the SoftDrainTimeout if set, otherwise
+// the ShutdownTimeout.
+func (g *Group) serviceShutdownTimeout() time.Duration {
+	if g.SoftDrainTimeout > 0 {
+		return g.SoftDrainTimeout
+	}
+	return g.ShutdownTimeout
+}
+
+// drainDeadlineSlack is how long past the latest in-flight request deadline shutdown waits for handlers to return.
+const drainDeadlineSlack = 100 * time.Millisecond
+
+// Shuts down an HTTP server once any PreShutdownDelay is over, within the server's own timeout (plus its hard drain
+// timeout, if set). If every in-flight request has a context deadline that falls within that timeout, the graceful
+// window is cut short to just past the latest of them (but never below drainDeadlineSlack, so handlers past their
+// deadlines still get to clean up), since those requests can't outlive their deadlines anyway. Attempts a graceful
+// shutdown and then a hard close before returning. The outcome is recorded for the shutdown summary.
+func (g *Group) shutdown(server *managedServer) error {
+	g.beginShutdown()
+	name := server.name
+	if g.PreShutdownDelay > 0 {
+		g.state.mu.Lock()
+		delay := time.Until(g.state.shutdownStarted.Add(g.PreShutdownDelay))
+		g.state.mu.Unlock()
+		if delay > 0 {
+			g.logf("Serving on %s for %s more so load balancers stop routing to it", name, delay)
+			time.Sleep(delay)
+		}
+	}
+	start := time.Now()
+	outcome := ServerShutdown{Name: name, Graceful: true}
+	defer func() {
+		outcome.Elapsed = time.Since(start)
+		g.recordServerShutdown(outcome)
+		if g.OnShutdownComplete != nil {
+			g.OnShutdownComplete(outcome.Name, outcome.Elapsed, outcome.Graceful)
+		}
+	}()
+	g.logf("Attempting graceful shutdown of %s on workgroup termination", name)
+	timeout := server.timeout
+	if server.requests != nil {
+		if latest, ok := server.requests.latestDeadline(); ok {
+			remaining := time.Until(latest) + drainDeadlineSlack
+			if remaining < drainDeadlineSlack {
+				remaining = drainDeadlineSlack // the deadlines have passed; give the handlers a moment to return
+			}
+			if remaining < timeout {
+				g.logf("All in-flight requests on %s end within %s; shortening graceful shutdown", name, remaining)
+				timeout = remaining
+			}
+		}
+	}
+	// a single Shutdown covers the hard drain too: calling it again would rerun the RegisterOnShutdown hooks
+	drainTimeout := timeout
+	var soft *time.Timer
+	if server.hardDrainTimeout > 0 {
+		drainTimeout += server.hardDrainTimeout
+		soft = time.AfterFunc(timeout, func() {
+			g.logf("%s still draining after %s; giving in-flight requests a final %s", name, timeout, server.hardDrainTimeout)
+		})
+	}
+	err := server.drain(drainTimeout, g.state.forced())
+	if soft != nil {
+		soft.Stop()
+	}
+	if err != nil {
+		g.logf("Error on graceful shutdown of %s: %s", name, err)
+		g.logf("Attempting hard shutdown of %s", name)
+		outcome.Graceful = false
+		outcome.ForceClosed = server.conns.open()
 		err = server.Close()
 		if err != nil {
 			err = fmt.Errorf("error while doing hard shutdown of %s: %s", name, err)
@@ -155,6 +1499,28 @@ func (g *Group) shutdown(server *http.Server, name string) error {
 		err = fmt.Errorf("%s on workgroup graceful shut down successful", name)
 	}
 
-	log.Print(err)
+	event, level := "server.shutdown.graceful", slog.LevelInfo
+	if !outcome.Graceful {
+		event, level = "server.shutdown.hard", slog.LevelWarn
+	}
+	g.logEventLevel(level, event, []slog.Attr{
+		slog.String("server", name),
+		slog.Duration("elapsed", time.Since(start)),
+		slog.Int("force_closed", outcome.ForceClosed),
+	}, "%s", err)
 	return err
 }
+
+// shutdownWithin gracefully shuts down server, giving up once timeout elapses or force is closed.
+func shutdownWithin(server *http.Server, timeout time.Duration, force <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-force:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return server.Shutdown(ctx)
+}
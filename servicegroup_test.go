@@ -1,86 +1,1171 @@
 package servicegroup
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 func TestNewWorkgroup_ShutsDownGracefully(t *testing.T) {
 	// * Spin up a service with a ping plus a slow "work" endpoint
-	// * When it comes up, make a "work" request then immediately send an interrupt
+	// * When it comes up, make a "work" request then send an interrupt as soon as the request is being handled
 	// * Validate that the "work" request gets a response before the server shuts down, and that shutdown took a
 	//   reasonable amount of time
 	workDuration := time.Duration(100) * time.Millisecond
+	workStarted := make(chan struct{})
 
 	mux := http.NewServeMux() // custom mux for our service (:8080)
 	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "pong")
 	})
 	mux.HandleFunc("/work", func(w http.ResponseWriter, r *http.Request) {
+		close(workStarted)
 		time.Sleep(workDuration)
 		fmt.Fprintf(w, "that took %v", workDuration)
 	})
 	group := NewGroup(mux)
 
-	process, err := os.FindProcess(os.Getpid())
-	Ok(t, err)
-	fmt.Printf("process is %v", process.Pid)
-
-	workResponseBody := make(chan string)
+	workResponseBody := make(chan string, 1)
+	client := &http.Client{Transport: &http.Transport{}} // own connection pool, so no other test's connections linger
 
 	// Wait until the server is available, then make a long-lived work request, send sigint, and send the work request
 	// results back on a channel.
 	go func() {
-		timeout := time.After(3 * time.Second)
-		for {
-			select {
-			case <-timeout:
-				Assert(t, false, "Timed out waiting for test server to become available.")
-				return
-			default:
-				resp, err := http.Get("http://127.0.0.1:8080/ping")
-				if err == nil {
-					resp.Body.Close()
-					Ok(t, err)
-					// Start a request to the slow endpoint in the background & send a sigint
-					go func() {
-						resp, err := http.Get("http://127.0.0.1:8080/work")
-						Ok(t, err)
-						body, err := ioutil.ReadAll(resp.Body)
-						resp.Body.Close()
-						Ok(t, err)
-						workResponseBody <- string(body)
-					}()
-					// Give the goroutine a beat to make the request, then ctrl+c
-					time.Sleep(time.Duration(1) * time.Millisecond)
-					err := process.Signal(syscall.SIGINT)
-					Ok(t, err)
-					return
-				}
-			}
-		}
+		WaitForURL(t, client, "http://127.0.0.1:8080/ping")
+		// Start a request to the slow endpoint in the background & send a sigint once it's being worked on
+		go func() {
+			resp, err := client.Get("http://127.0.0.1:8080/work")
+			Ok(t, err)
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			Ok(t, err)
+			workResponseBody <- string(body)
+		}()
+		<-workStarted
+		// an idle connection the server hasn't seen a request on yet would hold up shutdown for Go's 5s grace period
+		client.CloseIdleConnections()
+		group.Signal(syscall.SIGINT)
 	}()
 
 	startTime := time.Now()
-	err = group.Run()
+	err := group.Run()
 	select {
 	case body := <-workResponseBody:
 		Assert(t, time.Since(startTime) < time.Second*5, "Exceeded expected shutdown timing")
 		Assert(t, strings.HasPrefix(body, "that took"), "response body must match expected value")
-	default:
+	case <-time.After(time.Second):
 		Assert(t, false, "No response body received before server shutdown. Group shutdown root error: %s", err)
 	}
 }
 
+func TestNewWorkgroup_ShutdownDoesNotLeakGoroutines(t *testing.T) {
+	// * Note the goroutine count, then run a service and interrupt it once it's up
+	// * Validate that once Run returns, every goroutine the group started (servers, shutdown workers, signal watcher)
+	//   has exited, allowing the runtime a moment to settle
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	before := runtime.NumGoroutine()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	})
+	group := NewGroup(mux)
+
+	go func() {
+		WaitForURL(t, client, "http://127.0.0.1:8080/ping")
+		group.Signal(syscall.SIGINT)
+	}()
+	_ = group.Run()
+
+	AssertNoGoroutineLeak(t, before)
+}
+
+func TestNewWorkgroup_ReadyFile(t *testing.T) {
+	// * Run a service with a ready file configured
+	// * Validate the file exists once the service is reachable, and is gone once Run returns after an interrupt
+	readyFile := filepath.Join(os.TempDir(), fmt.Sprintf("servicegroup-ready-%d", os.Getpid()))
+	defer os.Remove(readyFile)
+	group := NewGroup(http.NotFoundHandler())
+	group.ReadyFilePath = readyFile
+
+	readyWhileServing := make(chan bool, 1)
+	go func() {
+		WaitForURL(t, http.DefaultClient, "http://127.0.0.1:8080/")
+		_, err := os.Stat(readyFile)
+		readyWhileServing <- err == nil
+		group.Signal(syscall.SIGINT)
+	}()
+	_ = group.Run()
+
+	Assert(t, <-readyWhileServing, "ready file must exist while serving")
+	_, err := os.Stat(readyFile)
+	Assert(t, os.IsNotExist(err), "ready file must be removed on shutdown, got %v", err)
+}
+
+func TestShutdown_SoftThenHardDrain(t *testing.T) {
+	// * Serve a request that outlives the soft drain timeout but fits within soft + hard
+	// * Validate the request completes, the server is reported as gracefully shut down, and its shutdown hooks ran once
+	group := NewGroup(nil)
+	group.SoftDrainTimeout = 50 * time.Millisecond
+	group.HardDrainTimeout = time.Second
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	})}
+	var hooks int32
+	server.RegisterOnShutdown(func() { atomic.AddInt32(&hooks, 1) })
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	go server.Serve(listener)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		Ok(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Ok(t, err)
+		body <- string(b)
+	}()
+	<-started
+
+	err = group.shutdown(&managedServer{
+		Server:           server,
+		name:             "test server",
+		conns:            newConnTracker(),
+		timeout:          group.serviceShutdownTimeout(),
+		hardDrainTimeout: group.HardDrainTimeout,
+	})
+	Assert(t, strings.Contains(err.Error(), "graceful"), "expected graceful shutdown, got: %s", err)
+	Equals(t, "done", <-body)
+	Equals(t, int32(1), atomic.LoadInt32(&hooks), "the hard drain must not shut the server down a second time")
+}
+
+func TestNewWorkgroup_DebugServerShutsDownFaster(t *testing.T) {
+	// * Hold a request open on each server, with a short DebugShutdownTimeout, then interrupt the group
+	// * Validate the debug server is forcibly closed at its own deadline, well before the service server gives up
+	started := make(chan struct{}, 2)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(time.Second)
+	})
+	listener, client := NewPipeListener()
+	group := NewGroup(slow)
+	group.ServiceListener = listener
+	group.DebugHandler = slow
+	group.ShutdownTimeout = 500 * time.Millisecond
+	group.DebugShutdownTimeout = 50 * time.Millisecond
+
+	debugClient := &http.Client{Transport: &http.Transport{}}
+	go func() {
+		<-group.Ready()
+		go debugClient.Get("http://127.0.0.1:6060/slow")
+		go client.Get("http://servicegroup/slow")
+		<-started
+		<-started
+		group.Signal(syscall.SIGINT)
+	}()
+	_ = group.Run()
+
+	elapsed := make(map[string]time.Duration)
+	for _, server := range group.state.shutdowns {
+		Assert(t, !server.Graceful, "%s must be cut off by its shutdown timeout", server.Name)
+		elapsed[server.Name] = server.Elapsed
+	}
+	Assert(t, elapsed["debug HTTP server"] < 200*time.Millisecond, "debug server must stop at DebugShutdownTimeout, took %s", elapsed["debug HTTP server"])
+	Assert(t, elapsed["service HTTP server"] >= 500*time.Millisecond, "service server must get the full ShutdownTimeout, took %s", elapsed["service HTTP server"])
+}
+
+func TestNewWorkgroup_AddServerShutsDownGracefully(t *testing.T) {
+	// * Run a group with an added admin server, each server with a request in flight, then interrupt it
+	// * Validate both requests complete and both servers report a graceful shutdown
+	started := make(chan struct{}, 2)
+	slow := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprint(w, body)
+		})
+	}
+	listener, client := NewPipeListener()
+	group := NewGroup(slow("public"))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.AddServer("127.0.0.1:8081", slow("admin"))
+
+	adminClient := &http.Client{Transport: &http.Transport{}}
+	bodies := make(chan string, 2)
+	get := func(client *http.Client, url string) {
+		resp, err := client.Get(url)
+		Ok(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Ok(t, err)
+		bodies <- string(b)
+	}
+	go func() {
+		<-group.Ready()
+		go get(client, "http://servicegroup/")
+		go get(adminClient, "http://127.0.0.1:8081/")
+		<-started
+		<-started
+		group.Signal(syscall.SIGINT)
+	}()
+	_ = group.Run()
+
+	got := []string{<-bodies, <-bodies}
+	sort.Strings(got)
+	Equals(t, []string{"admin", "public"}, got, "in-flight requests on both servers must complete")
+	Equals(t, 2, len(group.state.shutdowns))
+	for _, server := range group.state.shutdowns {
+		Assert(t, server.Graceful, "%s must shut down gracefully", server.Name)
+	}
+}
+
+func TestNewWorkgroup_ShutsDownOnParentDeath(t *testing.T) {
+	// * Fake the parent PID changing (as it does when we're reparented after our parent exits)
+	// * Validate that Run shuts down on its own, reporting the parent's exit
+	defer func(orig func() int, interval time.Duration) {
+		getppid, parentPollInterval = orig, interval
+	}(getppid, parentPollInterval)
+	var calls int32
+	getppid = func() int {
+		if atomic.AddInt32(&calls, 1) > 2 {
+			return 1
+		}
+		return 4242
+	}
+	parentPollInterval = 10 * time.Millisecond
+
+	group := NewGroup(http.NotFoundHandler())
+	group.ShutdownOnParentDeath = true
+	err := group.Run()
+	Assert(t, strings.Contains(err.Error(), "parent process 4242"), "unexpected Run error: %s", err)
+}
+
+func TestNewWorkgroup_ListsWorkers(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.Add(func(stop <-chan struct{}) error {
+		return fmt.Errorf("done")
+	})
+	Equals(t, []string{"worker-1"}, group.Workers())
+
+	err := group.Run()
+	Equals(t, "done", err.Error())
+	workers := strings.Join(group.Workers(), ",")
+	for _, name := range []string{"worker-1", "debug server", "service server", "service server shutdown", "signal watcher"} {
+		Assert(t, strings.Contains(workers, name), "worker %q missing from %s", name, workers)
+	}
+}
+
+func TestGroupLiteral_WorksWithoutNewGroup(t *testing.T) {
+	// * Configure and run a Group literal, as worked before NewGroup made its state
+	// * Validate its methods don't panic before Run, and Run serves and returns once the added worker does
+	listener, client := NewPipeListener()
+	group := Group{Handler: http.NotFoundHandler(), ServiceListener: listener, DisableDebugServer: true}
+	Equals(t, "", group.ServiceAddr(), "no address before Run")
+	group.Use(func(next http.Handler) http.Handler { return next })
+	group.RegisterOnShutdown(func() {})
+	group.AddServer("127.0.0.1:0", http.NotFoundHandler())
+	group.AddNamed("named", func(stop <-chan struct{}) error {
+		<-stop
+		return nil
+	})
+	finish := make(chan struct{})
+	group.Add(func(stop <-chan struct{}) error {
+		<-finish
+		return nil
+	})
+	group.PauseAccepting()
+	group.ResumeAccepting()
+	Equals(t, []string{"named", "worker-2"}, group.Workers())
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+	resp, err := client.Get("http://pipe/")
+	Ok(t, err)
+	resp.Body.Close()
+	Equals(t, http.StatusNotFound, resp.StatusCode)
+	client.CloseIdleConnections()
+	close(finish)
+	Ok(t, <-done)
+}
+
+func TestNewWorkgroup_RunsAgainAfterShutdown(t *testing.T) {
+	// * Run a group with a worker of its own, shut it down with a signal, then run it again on a new listener
+	// * Validate the second run serves requests, reruns the worker, and shuts down cleanly in turn
+	runs := 0
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	group.DisableDebugServer = true
+	group.AddNamed("runs", func(stop <-chan struct{}) error {
+		runs++
+		<-stop
+		return nil
+	})
+	run := func() {
+		listener, client := NewPipeListener()
+		group.ServiceListener = listener
+		done := make(chan error, 1)
+		go func() { done <- group.Run() }()
+		WaitForURL(t, client, "http://servicegroup/")
+		Assert(t, group.readyForTraffic(), "group must be ready while running")
+		client.CloseIdleConnections()
+		group.Signal(syscall.SIGINT)
+		err := <-done
+		Assert(t, strings.Contains(err.Error(), "interrupt"), "group must stop on the interrupt, got: %v", err)
+	}
+
+	run()
+	run()
+	Equals(t, 2, runs, "workers must run again on each Run")
+	serviceServers := 0
+	for _, name := range group.Workers() {
+		if name == "service server" {
+			serviceServers++
+		}
+	}
+	Equals(t, 1, serviceServers, "Run's own workers must not pile up across runs")
+}
+
+func TestNewWorkgroup_RunsAgainAfterPausing(t *testing.T) {
+	// * Run a group, pause accepting during the run, shut it down, then run it again
+	// * Validate the pause ends with the first run, so the second accepts and serves connections
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	group.DisableDebugServer = true
+	run := func(during func()) {
+		listener, client := NewPipeListener()
+		client.Timeout = time.Second // so a paused listener fails WaitForURL rather than hanging it
+		group.ServiceListener = listener
+		done := make(chan error, 1)
+		go func() { done <- group.Run() }()
+		WaitForURL(t, client, "http://servicegroup/")
+		client.CloseIdleConnections()
+		during()
+		group.Signal(syscall.SIGINT)
+		<-done
+	}
+
+	run(group.PauseAccepting)
+	run(func() {})
+}
+
+func TestNewWorkgroup_RunsReloadedHandlerAgain(t *testing.T) {
+	// * Run a group, reload its handler with SIGHUP, shut it down, then run it again
+	// * Validate the second run serves the reloaded handler rather than the original
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "original")
+	}))
+	group.DisableDebugServer = true
+	group.HandlerReloader = func() (http.Handler, error) {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "reloaded")
+		}), nil
+	}
+	get := func(client *http.Client) string {
+		resp, err := client.Get("http://servicegroup/")
+		Ok(t, err)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	run := func(during func(client *http.Client)) {
+		listener, client := NewPipeListener()
+		group.ServiceListener = listener
+		done := make(chan error, 1)
+		go func() { done <- group.Run() }()
+		WaitForURL(t, client, "http://servicegroup/")
+		during(client)
+		client.CloseIdleConnections()
+		group.Signal(syscall.SIGINT)
+		<-done
+	}
+
+	run(func(client *http.Client) {
+		Equals(t, "original", get(client))
+		group.Signal(syscall.SIGHUP)
+		deadline := time.Now().Add(3 * time.Second)
+		for get(client) != "reloaded" {
+			Assert(t, time.Now().Before(deadline), "SIGHUP must reload the handler")
+			time.Sleep(time.Millisecond)
+		}
+	})
+	run(func(client *http.Client) {
+		Equals(t, "reloaded", get(client), "the reloaded handler must carry over to the next Run")
+	})
+}
+
+func TestNewWorkgroup_ShutdownStopsRun(t *testing.T) {
+	// * Start Run in a goroutine, then call Shutdown from two goroutines at once
+	// * Validate both calls return once the servers have stopped, and Run returns ErrShutdown
+	listener, client := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+	WaitForURL(t, client, "http://servicegroup/")
+	client.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- group.Shutdown(ctx) }()
+	}
+	Ok(t, <-results)
+	Ok(t, <-results)
+	select {
+	case err := <-done:
+		Assert(t, errors.Is(err, ErrShutdown), "Run must return ErrShutdown, got: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Run must return once Shutdown returns")
+	}
+	Ok(t, group.Shutdown(ctx))
+}
+
+func TestNewWorkgroup_OneShotWorkerKeepsGroupRunning(t *testing.T) {
+	// * Run a group with a one-shot worker that finishes successfully
+	// * Validate the group carries on serving afterwards, and still stops on the signal
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	warmed := make(chan struct{})
+	group.AddOneShot(func(stop <-chan struct{}) error {
+		close(warmed)
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+
+	<-warmed
+	WaitForURL(t, client, "http://servicegroup/")
+	resp, err := client.Get("http://servicegroup/")
+	Ok(t, err)
+	resp.Body.Close()
+	Equals(t, http.StatusOK, resp.StatusCode)
+	select {
+	case err := <-done:
+		t.Fatalf("one-shot worker finishing must not stop the group, got: %v", err)
+	default:
+	}
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	err = <-done
+	Assert(t, strings.Contains(err.Error(), "interrupt"), "group must stop on the interrupt, got: %v", err)
+}
+
+func TestNewWorkgroup_OneShotWorkerErrorStopsGroup(t *testing.T) {
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.AddOneShot(func(stop <-chan struct{}) error {
+		return fmt.Errorf("warm-up failed")
+	})
+	Equals(t, "warm-up failed", group.Run().Error())
+}
+
+func TestNewWorkgroup_OnShutdownComplete(t *testing.T) {
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DebugServerAddr = "127.0.0.1:0"
+	var mu sync.Mutex
+	completed := map[string]bool{}
+	group.OnShutdownComplete = func(name string, elapsed time.Duration, graceful bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		Assert(t, elapsed > 0 && elapsed < time.Second, "%s shutdown must report how long it took, got %s", name, elapsed)
+		completed[name] = graceful
+	}
+	group.Signal(syscall.SIGINT)
+	group.Run()
+
+	mu.Lock()
+	defer mu.Unlock()
+	Equals(t, map[string]bool{"debug HTTP server": true, "service HTTP server": true}, completed)
+}
+
+func TestNewWorkgroup_PostShutdownRunsAfterServers(t *testing.T) {
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DebugServerAddr = "127.0.0.1:0"
+	group.ShutdownTimeout = 2 * time.Second
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	group.OnShutdownComplete = func(name string, elapsed time.Duration, graceful bool) {
+		record(name)
+	}
+	var remaining time.Duration
+	group.AddPostShutdown(func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		remaining = time.Until(deadline)
+		record("post-shutdown")
+		return nil
+	})
+	group.Signal(syscall.SIGINT)
+	group.Run()
+
+	Equals(t, 3, len(events))
+	Equals(t, "post-shutdown", events[2], "post-shutdown func must run after both servers have shut down")
+	Assert(t, remaining > time.Second && remaining <= 2*time.Second, "post-shutdown deadline must be the rest of ShutdownTimeout, got %s", remaining)
+}
+
+func TestNewWorkgroup_TogglesKeepAlives(t *testing.T) {
+	// * Run a group, turning keep-alives off and back on while it runs
+	// * Validate responses carry "Connection: close" only while they're off
+	listener, client := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.DisableKeepAlives = true
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	WaitForURL(t, client, "http://servicegroup/")
+
+	closes := func() bool {
+		resp, err := client.Get("http://servicegroup/")
+		Ok(t, err)
+		resp.Body.Close()
+		return resp.Close // set by the client from the "Connection: close" header, which it then strips
+	}
+	Assert(t, closes(), "responses must close the connection with DisableKeepAlives")
+	group.SetKeepAlivesEnabled(true)
+	Assert(t, !closes(), "responses must keep the connection alive once keep-alives are enabled")
+	group.SetKeepAlivesEnabled(false)
+	Assert(t, closes(), "responses must close the connection once keep-alives are disabled")
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_SignalIsCleanExit(t *testing.T) {
+	run := func(clean bool) error {
+		listener, _ := NewPipeListener()
+		group := NewGroup(http.NotFoundHandler())
+		group.ServiceListener = listener
+		group.DisableDebugServer = true
+		group.SignalIsCleanExit = clean
+		group.Signal(syscall.SIGTERM)
+		return group.Run()
+	}
+	Ok(t, run(true))
+	err := run(false)
+	Assert(t, err != nil && strings.Contains(err.Error(), "terminated"), "signal must be an error by default, got: %v", err)
+
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.SignalIsCleanExit = true
+	group.Add(func(stop <-chan struct{}) error {
+		return fmt.Errorf("crashed")
+	})
+	Equals(t, "crashed", group.Run().Error(), "worker failures must still be errors")
+}
+
+func TestNewWorkgroup_ForceOnSecondSignal(t *testing.T) {
+	// * Run a group whose handler never finishes, and start a request
+	// * Signal it twice: the first begins a graceful shutdown, the second must hard close the servers right away
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	listener, client := NewPipeListener()
+	group := NewGroup(handler)
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ShutdownTimeout = 10 * time.Second
+	var mu sync.Mutex
+	var graceful []bool
+	group.OnShutdownComplete = func(name string, elapsed time.Duration, ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		graceful = append(graceful, ok)
+	}
+	Assert(t, group.ForceOnSecondSignal, "forcing on a second signal must be the default")
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	go client.Get("http://pipe/")
+	for group.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	group.Signal(syscall.SIGINT)
+	for atomic.LoadInt32(&group.state.shuttingDown) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	group.Signal(syscall.SIGINT)
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("second signal must cut the graceful shutdown short")
+	}
+	Assert(t, time.Since(start) < 3*time.Second, "second signal must hard close quickly")
+	mu.Lock()
+	defer mu.Unlock()
+	Equals(t, []bool{false}, graceful)
+}
+
+func TestNewWorkgroup_ServerClosedIsNotTheShutdownReason(t *testing.T) {
+	// * Signal a running group, whose servers' Serve calls then return http.ErrServerClosed
+	// * Validate Run returns the signal as the reason, and a server closed by someone else is a clean stop
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DebugServerAddr = "127.0.0.1:0"
+	group.Signal(syscall.SIGTERM)
+	err := group.Run()
+	var signalled *signalShutdown
+	Assert(t, errors.As(err, &signalled), "Run must return the signal that stopped it, got: %v", err)
+	Assert(t, !errors.Is(err, http.ErrServerClosed), "ErrServerClosed must not be reported as the reason, got: %v", err)
+
+	listener, _ = NewPipeListener()
+	group = NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.DisableSignalWatcher = true
+	var server *http.Server
+	group.ConfigureServiceServer = func(s *http.Server) { server = s }
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+	Ok(t, server.Shutdown(context.Background()))
+	Ok(t, <-done)
+}
+
+func TestNewWorkgroup_ServiceNetwork(t *testing.T) {
+	// * Run a group pinned to IPv4 on all interfaces
+	// * Validate it binds the IPv4 wildcard address and serves over IPv4, and that tcp6 won't bind an IPv4 address
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ipv4")
+	}), WithServiceNetwork("tcp4"), WithServiceAddr(":0"), WithDebugServerDisabled())
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	host, port, err := net.SplitHostPort(group.ServiceAddr())
+	Ok(t, err)
+	Equals(t, "0.0.0.0", host, "tcp4 must bind the IPv4 wildcard address")
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Get("http://127.0.0.1:" + port)
+	Ok(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Equals(t, "ipv4", string(body))
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+
+	ipv6 := NewGroup(http.NotFoundHandler(), WithServiceNetwork("tcp6"), WithServiceAddr("127.0.0.1:0"), WithDebugServerDisabled())
+	err = ipv6.Run()
+	Assert(t, errors.Is(err, ErrBindFailed), "tcp6 must not bind an IPv4 address, got: %v", err)
+}
+
+func TestNewWorkgroup_ShutdownOrder(t *testing.T) {
+	for order, want := range map[ShutdownOrder][]string{
+		ShutdownDebugFirst:   {"debug HTTP server", "service HTTP server"},
+		ShutdownServiceFirst: {"service HTTP server", "debug HTTP server"},
+	} {
+		listener, _ := NewPipeListener()
+		group := NewGroup(http.NotFoundHandler())
+		group.ServiceListener = listener
+		group.DebugServerAddr = "127.0.0.1:0"
+		group.ShutdownOrder = order
+		var mu sync.Mutex
+		var completed []string
+		group.OnShutdownComplete = func(name string, elapsed time.Duration, graceful bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			completed = append(completed, name)
+		}
+		group.Signal(syscall.SIGINT)
+		group.Run()
+		Equals(t, want, completed, order)
+	}
+
+	group := NewGroup(http.NotFoundHandler())
+	group.ShutdownOrder = "sideways"
+	err := group.Run()
+	Assert(t, err != nil && strings.Contains(err.Error(), "sideways"), "Run must refuse an unknown ShutdownOrder, got: %v", err)
+}
+
+func TestNewWorkgroup_ConfigureServers(t *testing.T) {
+	// * Run a group whose hooks give the servers their own ErrorLog, with a handler that misuses its ResponseWriter
+	// * Validate the service server's complaint goes to the ErrorLog set by the hook
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(http.StatusTeapot) // logged by net/http as superfluous
+	}))
+	group.ServiceListener = listener
+	group.DebugServerAddr = "127.0.0.1:0"
+	var errorLog syncBuffer
+	group.ConfigureServiceServer = func(server *http.Server) {
+		server.ErrorLog = log.New(&errorLog, "service: ", 0)
+	}
+	var debugServer *http.Server
+	group.ConfigureDebugServer = func(server *http.Server) {
+		debugServer = server
+	}
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	WaitForURL(t, client, "http://servicegroup/")
+
+	logged := string(errorLog.Bytes())
+	Assert(t, strings.Contains(logged, "service: http: superfluous response.WriteHeader call"),
+		"server errors must go to the configured ErrorLog, got %q", logged)
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+	Assert(t, debugServer != nil && debugServer.Handler != nil, "debug hook must get the configured debug server")
+}
+
+func TestNewWorkgroup_NamedWorkerErrors(t *testing.T) {
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.AddNamed("cache warmer", func(stop <-chan struct{}) error {
+		<-stop
+		return nil
+	})
+	group.AddNamed("queue consumer", func(stop <-chan struct{}) error {
+		return fmt.Errorf("connection refused")
+	})
+
+	err := group.Run()
+	Assert(t, strings.Contains(err.Error(), "queue consumer"), "error must name the failed worker, got: %v", err)
+	var workerErr *WorkerError
+	Assert(t, errors.As(err, &workerErr), "error must be a WorkerError, got: %T", err)
+	Equals(t, "queue consumer", workerErr.Name)
+	Equals(t, "connection refused", errors.Unwrap(workerErr).Error())
+	workers := strings.Join(group.Workers(), ",")
+	Assert(t, strings.Contains(workers, "cache warmer") && strings.Contains(workers, "queue consumer"),
+		"named workers must be listed, got %s", workers)
+}
+
+func TestNewWorkgroup_RestartsResilientWorker(t *testing.T) {
+	// * Run a group with a resilient worker that fails twice, then keeps running
+	// * Validate it's restarted with growing backoff rather than stopping the group, which stops on the signal instead
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	var attempts []time.Time
+	running := make(chan struct{})
+	group.AddResilient("queue consumer", func(stop <-chan struct{}) error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) <= 2 {
+			return fmt.Errorf("transient failure %d", len(attempts))
+		}
+		close(running)
+		<-stop
+		return nil
+	}, 3, 10*time.Millisecond)
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+
+	<-running
+	group.Signal(syscall.SIGINT)
+	err := <-done
+	Assert(t, strings.Contains(err.Error(), "interrupt"), "group must stop on the signal, not the worker, got: %v", err)
+	Equals(t, 3, len(attempts))
+	Assert(t, attempts[1].Sub(attempts[0]) >= 10*time.Millisecond, "first retry must wait the backoff")
+	Assert(t, attempts[2].Sub(attempts[1]) >= 20*time.Millisecond, "backoff must double between retries")
+}
+
+func TestNewWorkgroup_ResilientWorkerGivesUp(t *testing.T) {
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	attempts := 0
+	group.AddResilient("queue consumer", func(stop <-chan struct{}) error {
+		attempts++
+		return fmt.Errorf("broker gone")
+	}, 2, time.Millisecond)
+
+	err := group.Run()
+	var workerErr *WorkerError
+	Assert(t, errors.As(err, &workerErr), "exhausted worker must stop the group with a WorkerError, got: %v", err)
+	Equals(t, "queue consumer", workerErr.Name)
+	Equals(t, 3, attempts, "worker must run once plus maxRetries times")
+}
+
+func TestNewWorkgroup_ContextWorkerGetsShutdownDeadline(t *testing.T) {
+	// * Run a group with an AddContext worker that waits for its context, then takes a CleanupContext
+	// * Validate the worker's context never reports a deadline, and the cleanup context's is ShutdownTimeout after the stop
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ShutdownTimeout = 2 * time.Second
+	running := make(chan struct{})
+	var deadline time.Time
+	var hadDeadline, hadDeadlineAfterStop, hadCleanupDeadline bool
+	var ctxErr, cleanupErr error
+	group.AddContext(func(ctx context.Context) error {
+		_, hadDeadline = ctx.Deadline()
+		close(running)
+		<-ctx.Done()
+		ctxErr = ctx.Err()
+		_, hadDeadlineAfterStop = ctx.Deadline()
+		cleanup, cancel := CleanupContext(ctx)
+		defer cancel()
+		deadline, hadCleanupDeadline = cleanup.Deadline()
+		cleanupErr = cleanup.Err()
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+
+	<-running
+	stopped := time.Now()
+	group.Signal(syscall.SIGINT)
+	<-done
+	Assert(t, !hadDeadline && !hadDeadlineAfterStop, "worker context must never report a deadline")
+	Equals(t, context.Canceled, ctxErr)
+	Assert(t, hadCleanupDeadline, "cleanup context must have a deadline")
+	Equals(t, nil, cleanupErr, "cleanup context must still be live when the worker's is cancelled")
+	remaining := deadline.Sub(stopped)
+	Assert(t, remaining > 1500*time.Millisecond && remaining <= 2*time.Second+100*time.Millisecond,
+		"cleanup context deadline must be about ShutdownTimeout after the stop, got %s", remaining)
+}
+
+func TestNewWorkgroup_ShutdownSummary(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	var summary ShutdownSummary
+	group.OnShutdownSummary = func(s ShutdownSummary) {
+		summary = s
+	}
+	group.Add(func(stop <-chan struct{}) error {
+		return fmt.Errorf("worker failed")
+	})
+
+	err := group.Run()
+	Equals(t, err, summary.Reason)
+	Equals(t, 2, len(summary.Servers), "summary must cover both servers: %+v", summary.Servers)
+	for _, server := range summary.Servers {
+		Assert(t, server.Graceful, "%s must shut down gracefully", server.Name)
+	}
+	Assert(t, strings.HasPrefix(summary.String(), "Shutdown complete in "), "unexpected summary line: %s", summary)
+}
+
+func TestNewWorkgroup_DebugNetwork(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	Equals(t, "tcp", group.DebugNetwork, "debug network must default to tcp")
+
+	group.DebugNetwork = "udp"
+	err := group.Run()
+	Assert(t, err != nil && strings.Contains(err.Error(), "udp"), "Run must fail to listen on udp, got: %v", err)
+}
+
+func TestNewWorkgroup_ReportsWhichServerFailedToStart(t *testing.T) {
+	// * Occupy the debug port, then run a group
+	// * Validate Run fails with a ServerError naming the debug server
+	taken, err := net.Listen("tcp", ":6060")
+	Ok(t, err)
+	defer taken.Close()
+
+	group := NewGroup(http.NotFoundHandler())
+	err = group.Run()
+	var serverErr *ServerError
+	Assert(t, errors.As(err, &serverErr), "Run must return a ServerError, got: %v", err)
+	Equals(t, "debug", serverErr.Component)
+	Equals(t, ":6060", serverErr.Addr)
+}
+
+func TestNewWorkgroup_ReportsWhichAddedServerFailedToStart(t *testing.T) {
+	// * Occupy a port, then run groups adding a server on it with AddServer and with AddNamedServer
+	// * Validate the ServerError's Component names the added server by its address, or by the name it was added with
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	defer taken.Close()
+	addr := taken.Addr().String()
+
+	for component, add := range map[string]func(*Group){
+		"added " + addr: func(g *Group) { g.AddServer(addr, http.NotFoundHandler()) },
+		"admin":         func(g *Group) { g.AddNamedServer("admin", addr, http.NotFoundHandler()) },
+	} {
+		listener, _ := NewPipeListener()
+		group := NewGroup(http.NotFoundHandler())
+		group.ServiceListener = listener
+		group.DisableDebugServer = true
+		group.AddServer("127.0.0.1:0", http.NotFoundHandler()) // another added server, which binds fine
+		add(&group)
+		err := group.Run()
+		var serverErr *ServerError
+		Assert(t, errors.As(err, &serverErr), "Run must return a ServerError, got: %v", err)
+		Equals(t, component, serverErr.Component)
+		Equals(t, addr, serverErr.Addr)
+		Assert(t, errors.Is(err, ErrBindFailed), "Run must fail with ErrBindFailed, got: %v", err)
+	}
+}
+
+func TestNewWorkgroup_FailsFastOnBindFailure(t *testing.T) {
+	// * Occupy a port, then run a group whose service server is configured to listen on it
+	// * Validate Run fails straight away with ErrBindFailed, wrapping the address-in-use error, without starting workers
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	defer taken.Close()
+
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceServerAddr = taken.Addr().String()
+	group.DisableDebugServer = true
+	err = group.Run()
+	Assert(t, errors.Is(err, ErrBindFailed), "Run must fail with ErrBindFailed, got: %v", err)
+	Assert(t, errors.Is(err, syscall.EADDRINUSE), "bind error must wrap the underlying cause, got: %v", err)
+	Assert(t, strings.Contains(err.Error(), taken.Addr().String()), "bind error must name the address, got: %v", err)
+	Equals(t, 0, len(group.Workers()), "no workers must be started when binding fails")
+	Assert(t, !errors.Is(&ServerError{Component: "service", Err: http.ErrHandlerTimeout}, ErrBindFailed), "serve errors must not match ErrBindFailed")
+}
+
+func TestShutdown_EndsAtLatestRequestDeadline(t *testing.T) {
+	// * Serve a request whose context deadline is well inside ShutdownTimeout, from a handler that ignores it
+	// * Validate that shutdown gives up just after the request's deadline rather than waiting out ShutdownTimeout
+	var cancels []context.CancelFunc
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	started := make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(2 * time.Second)
+	}))
+	group.ShutdownTimeout = 10 * time.Second
+	group.ExtractTraceContext = func(r *http.Request) context.Context {
+		ctx, cancel := context.WithTimeout(r.Context(), 100*time.Millisecond)
+		cancels = append(cancels, cancel)
+		return ctx
+	}
+
+	server := &http.Server{Handler: group.serviceHandler()}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	go server.Serve(listener)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	start := time.Now()
+	_ = group.shutdown(&managedServer{
+		Server:   server,
+		name:     "test server",
+		conns:    newConnTracker(),
+		requests: group.state.inflight,
+		timeout:  group.serviceShutdownTimeout(),
+	})
+	Assert(t, time.Since(start) < time.Second, "shutdown took %s, expected it to end near the request deadline", time.Since(start))
+}
+
+func TestShutdown_WaitsForHandlersPastTheirDeadline(t *testing.T) {
+	// * Serve a request whose deadline has already passed by the time shutdown starts, from a handler still cleaning up
+	// * Validate shutdown still gives the handler a moment to return rather than closing the server straight away
+	var cancel context.CancelFunc
+	defer func() { cancel() }()
+	started := make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		time.Sleep(30 * time.Millisecond) // cleaning up
+	}))
+	group.ShutdownTimeout = 10 * time.Second
+	group.ExtractTraceContext = func(r *http.Request) context.Context {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(r.Context(), 20*time.Millisecond)
+		return ctx
+	}
+
+	server := &http.Server{Handler: group.serviceHandler()}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	go server.Serve(listener)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	time.Sleep(40 * time.Millisecond) // past the deadline, but not the cleanup
+
+	_ = group.shutdown(&managedServer{
+		Server:   server,
+		name:     "test server",
+		conns:    newConnTracker(),
+		requests: group.state.inflight,
+		timeout:  group.serviceShutdownTimeout(),
+	})
+	Assert(t, group.state.shutdowns[0].Graceful, "shutdown must wait out the handler's cleanup")
+}
+
+func TestNewWorkgroup_ServesDuringPreShutdownDelay(t *testing.T) {
+	// * Interrupt a group with a PreShutdownDelay
+	// * Validate that once shutdown has begun, new requests are still served until the delay is over
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "still here")
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.PreShutdownDelay = 200 * time.Millisecond
+	group.Signal(syscall.SIGINT)
+
+	served := make(chan string, 1)
+	go func() {
+		for group.state.currentPhase() != phaseShuttingDown {
+			time.Sleep(time.Millisecond)
+		}
+		resp, err := client.Get("http://servicegroup/")
+		Ok(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Ok(t, err)
+		served <- string(body)
+	}()
+
+	start := time.Now()
+	_ = group.Run()
+	Assert(t, time.Since(start) >= 200*time.Millisecond, "shutdown must wait out PreShutdownDelay")
+	Equals(t, "still here", <-served, "requests must be served during PreShutdownDelay")
+}
+
+func TestNewWorkgroup_ReadyOnceListening(t *testing.T) {
+	// * Run a group, and block until it's ready
+	// * Validate a request succeeds straight away, and that OnReady was called first
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	var onReadyCalled int32
+	group.OnReady = func() { atomic.StoreInt32(&onReadyCalled, 1) }
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+	resp, err := client.Get("http://servicegroup/ping")
+	Ok(t, err)
+	resp.Body.Close()
+	Equals(t, http.StatusOK, resp.StatusCode)
+	Equals(t, int32(1), atomic.LoadInt32(&onReadyCalled), "OnReady must be called once listening")
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_OnShutdownRunsOnceFirst(t *testing.T) {
+	// * Run a group with both servers and a hook that fails, then interrupt it
+	// * Validate the hook ran exactly once, before any server had shut down, and shutdown still completed
+	group := NewGroup(http.NotFoundHandler())
+	calls := 0
+	var shutDownBeforeHook int
+	group.OnShutdown = func(ctx context.Context) error {
+		calls++
+		_, hasDeadline := ctx.Deadline()
+		Assert(t, hasDeadline, "OnShutdown context must be bounded by ShutdownTimeout")
+		group.state.mu.Lock()
+		shutDownBeforeHook = len(group.state.shutdowns)
+		group.state.mu.Unlock()
+		return fmt.Errorf("producer already closed")
+	}
+	group.Signal(syscall.SIGINT)
+	_ = group.Run()
+	Equals(t, 1, calls, "OnShutdown must run exactly once")
+	Equals(t, 0, shutDownBeforeHook, "OnShutdown must run before any server shuts down")
+	Equals(t, 2, len(group.state.shutdowns), "both servers must still shut down")
+}
+
+func TestNewWorkgroup_PostDrainHold(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.PostDrainHold = 100 * time.Millisecond
+	var drained time.Time
+	group.OnShutdownSummary = func(ShutdownSummary) {
+		drained = time.Now()
+	}
+	group.Add(func(stop <-chan struct{}) error {
+		return fmt.Errorf("done")
+	})
+
+	_ = group.Run()
+	Assert(t, time.Since(drained) >= 100*time.Millisecond, "Run must hold for PostDrainHold after draining")
+}
+
+func TestNewWorkgroup_FlushesLoggerLast(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	var events []string
+	group.OnShutdownSummary = func(ShutdownSummary) {
+		events = append(events, "summary")
+	}
+	group.LoggerFlush = func() error {
+		events = append(events, "flush")
+		return nil
+	}
+	group.Add(func(stop <-chan struct{}) error {
+		return fmt.Errorf("done")
+	})
+
+	_ = group.Run()
+	Equals(t, []string{"summary", "flush"}, events, "logger must be flushed after the shutdown is logged")
+}
+
 // Test helpers for common tasks that don't require leaking heavy test libraries as module
 // dependencies to consumers. Slight variation of https://github.com/benbjohnson/testing
 
@@ -123,5 +1208,609 @@ func Equals(tb testing.TB, exp, act interface{}, msgAndArgs ...interface{}) {
 	}
 }
 
+func TestRunContext_ShutsDownGracefullyOnCancel(t *testing.T) {
+	// * Run a group with a cancellable context and start a slow request
+	// * Cancel the context while the request is in flight
+	// * Validate the request still completes, and Run returns the cancellation well within ShutdownTimeout
+	workStarted := make(chan struct{})
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(workStarted)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ShutdownTimeout = 5 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body := make(chan string, 1)
+	go func() {
+		resp, err := client.Get("http://servicegroup/work")
+		Ok(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Ok(t, err)
+		body <- string(b)
+	}()
+	go func() {
+		<-workStarted
+		cancel()
+	}()
+
+	start := time.Now()
+	err := group.RunContext(ctx)
+	Assert(t, errors.Is(err, context.Canceled), "Run must return the context's error, got: %v", err)
+	Assert(t, time.Since(start) < group.ShutdownTimeout, "shutdown must not wait out ShutdownTimeout")
+	Equals(t, "done", <-body, "in-flight request must complete")
+}
+
+func TestNewWorkgroup_ServesCustomListener(t *testing.T) {
+	// * Run a group on an ephemeral-port listener, ignoring ServiceServerAddr
+	// * Validate requests succeed against the listener's resolved address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	group.ServiceListener = listener
+	group.ServiceServerAddr = "256.0.0.1:1" // must not be bound
+	group.DisableDebugServer = true
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	client := &http.Client{Transport: &http.Transport{}}
+	url := "http://" + listener.Addr().String() + "/ping"
+	WaitForURL(t, client, url)
+	resp, err := client.Get(url)
+	Ok(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Ok(t, err)
+	Equals(t, "pong", string(body))
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	err = <-done
+	Assert(t, strings.Contains(err.Error(), "interrupt"), "group must stop on the interrupt, got: %v", err)
+}
+
+func TestNewWorkgroup_ServesH2C(t *testing.T) {
+	// * Run a group with h2c enabled and make a slow prior-knowledge HTTP/2 request, interrupting the group mid-request
+	// * Validate the request was served over HTTP/2 and still completed, and the server shut down gracefully
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	workStarted := make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(workStarted)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, r.Proto)
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.EnableH2C = true
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	type result struct {
+		proto, body string
+	}
+	results := make(chan result, 1)
+	go func() {
+		<-group.Ready()
+		resp, err := client.Get("http://" + listener.Addr().String() + "/work")
+		Ok(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Ok(t, err)
+		results <- result{resp.Proto, string(body)}
+	}()
+	go func() {
+		<-workStarted
+		group.Signal(syscall.SIGINT)
+	}()
+	_ = group.Run()
+
+	r := <-results
+	Equals(t, result{"HTTP/2.0", "HTTP/2.0"}, r, "request must be served over HTTP/2")
+	Assert(t, group.state.shutdowns[0].Graceful, "h2c server must shut down gracefully")
+}
+
+func TestNewWorkgroup_H2CSendsGoAwayOnShutdown(t *testing.T) {
+	// * Open a prior-knowledge h2c connection to a group, then interrupt it
+	// * Validate the server sends the connection a GOAWAY as it shuts down
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.EnableH2C = true
+	group.ShutdownTimeout = time.Second
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	Ok(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, http2.ClientPreface)
+	Ok(t, err)
+	framer := http2.NewFramer(conn, conn)
+	Ok(t, framer.WriteSettings())
+	frame, err := framer.ReadFrame()
+	Ok(t, err)
+	_, ok := frame.(*http2.SettingsFrame)
+	Assert(t, ok, "server must open with its SETTINGS, got %T", frame)
+
+	group.Signal(syscall.SIGINT)
+	for {
+		frame, err := framer.ReadFrame()
+		Ok(t, err)
+		if _, ok := frame.(*http2.GoAwayFrame); ok {
+			break
+		}
+	}
+	conn.Close()
+	<-done
+}
+
+func TestNewWorkgroup_ServesH2CAlongsideTLS(t *testing.T) {
+	// * Run a TLS group with h2c enabled and a TLSNextProto of its own
+	// * Validate TLS clients still negotiate h2, and the caller's TLSConfig and TLSNextProto are left untouched
+	certPEM, keyPEM := selfSignedCert(t)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	Ok(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.EnableH2C = true
+	group.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	group.ServiceTLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+		"custom": func(*http.Server, *tls.Conn, http.Handler) {},
+	}
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	client := &http.Client{Transport: &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/")
+	Ok(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Equals(t, "HTTP/2.0", string(body), "TLS clients must negotiate h2")
+	Equals(t, 0, len(group.TLSConfig.NextProtos), "the caller's TLSConfig must not be changed")
+	Equals(t, 1, len(group.ServiceTLSNextProto), "the caller's TLSNextProto must not be changed")
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_ServiceTLSNextProto(t *testing.T) {
+	// * Run a TLS group with a ServiceTLSNextProto handler for a custom ALPN protocol
+	// * Validate a client negotiating it is handed to that handler as a *tls.Conn, while HTTP clients still get h2
+	certPEM, keyPEM := selfSignedCert(t)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	Ok(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	taken := make(chan string, 1)
+	group.ServiceTLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+		"custom": func(server *http.Server, conn *tls.Conn, handler http.Handler) {
+			taken <- conn.ConnectionState().NegotiatedProtocol
+			conn.Write([]byte("custom protocol"))
+			conn.Close()
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"custom"}})
+	Ok(t, err)
+	Equals(t, "custom", conn.ConnectionState().NegotiatedProtocol, "the custom protocol must be negotiated")
+	greeting, _ := ioutil.ReadAll(conn)
+	conn.Close()
+	Equals(t, "custom", <-taken, "the custom protocol's handler must get the connection")
+	Equals(t, "custom protocol", string(greeting))
+
+	client := &http.Client{Transport: &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/")
+	Ok(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Equals(t, "HTTP/2.0", string(body), "h2 must still be negotiated when ServiceTLSNextProto doesn't override it")
+	Equals(t, 0, len(group.TLSConfig.NextProtos), "the caller's TLSConfig must not be changed")
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_ServiceReadTimeoutCutsOffSlowBodies(t *testing.T) {
+	// * Run a group with a short ServiceReadTimeout, and send a request whose body trickles in too slowly
+	// * Validate the handler's body read fails once the timeout passes
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	readErr := make(chan error, 1)
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ioutil.ReadAll(r.Body)
+		readErr <- err
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ServiceReadTimeout = 100 * time.Millisecond
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	Ok(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "POST /upload HTTP/1.1\r\nHost: servicegroup\r\nContent-Length: 10\r\n\r\nx")
+	Ok(t, err)
+	select {
+	case err := <-readErr:
+		Assert(t, err != nil, "slow body read must be cut off by ServiceReadTimeout")
+	case <-time.After(2 * time.Second):
+		Assert(t, false, "slow body read was not cut off")
+	}
+
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_ServiceMaxHeaderBytes(t *testing.T) {
+	// * Run a group with a low ServiceMaxHeaderBytes
+	// * Validate a request with headers well over the limit is rejected with 431, and a small one is served
+	listener, client := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ServiceMaxHeaderBytes = 1024
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	get := func(cookie string) int {
+		req, err := http.NewRequest("GET", "http://servicegroup/", nil)
+		Ok(t, err)
+		req.Header.Set("Cookie", cookie)
+		resp, err := client.Do(req)
+		Ok(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	Equals(t, http.StatusNotFound, get("small=1"))
+	// net/http allows some slack over MaxHeaderBytes, so go well over it
+	Equals(t, http.StatusRequestHeaderFieldsTooLarge, get("big="+strings.Repeat("x", 16<<10)))
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_ServiceBaseContext(t *testing.T) {
+	// * Run a group whose base context carries a value, and interrupt it while a request is in flight
+	// * Validate the handler sees the value, and that its context isn't cancelled while it drains
+	type ctxKey struct{}
+	listener, client := NewPipeListener()
+	workStarted := make(chan struct{})
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(workStarted)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintf(w, "%v %v", r.Context().Value(ctxKey{}), r.Context().Err())
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ServiceBaseContext = func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), ctxKey{}, "seeded")
+	}
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := client.Get("http://servicegroup/")
+		Ok(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Ok(t, err)
+		body <- string(b)
+	}()
+	go func() {
+		<-workStarted
+		group.Signal(syscall.SIGINT)
+	}()
+	_ = group.Run()
+	Equals(t, "seeded <nil>", <-body)
+}
+
+func TestNewWorkgroup_ServiceConnContext(t *testing.T) {
+	type ctxKey struct{}
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value(ctxKey{}))
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ServiceConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, ctxKey{}, "conn from "+c.RemoteAddr().String())
+	}
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	resp, err := client.Get("http://servicegroup/")
+	Ok(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Ok(t, err)
+	Equals(t, "conn from pipe", string(body))
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_ResolvesBoundAddrs(t *testing.T) {
+	// * Run a group on ephemeral ports
+	// * Validate the resolved addresses are reported, and that a request to the resolved service port succeeds
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	group.ServiceServerAddr = "127.0.0.1:0"
+	group.DebugServerAddr = "127.0.0.1:0"
+	Equals(t, "", group.ServiceAddr(), "no address before Run")
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	for group.state.currentPhase() == phaseIdle {
+		time.Sleep(time.Millisecond)
+	}
+	addr := group.ServiceAddr()
+	Assert(t, strings.HasPrefix(addr, "127.0.0.1:") && addr != "127.0.0.1:0", "service port must be resolved, got %q", addr)
+	debugAddr := group.DebugAddr()
+	Assert(t, strings.HasPrefix(debugAddr, "127.0.0.1:") && debugAddr != "127.0.0.1:0", "debug port must be resolved, got %q", debugAddr)
+
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Get("http://" + addr + "/ping")
+	Ok(t, err)
+	resp.Body.Close()
+	Equals(t, http.StatusOK, resp.StatusCode)
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+func TestNewWorkgroup_ServesTLS(t *testing.T) {
+	// * Run a group with a certificate and key from files
+	// * Validate requests are served over TLS, and that the group still shuts down gracefully
+	dir, err := ioutil.TempDir("", "servicegroup")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	certPEM, keyPEM := selfSignedCert(t)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	Ok(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	Ok(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	listener, client := NewPipeListener()
+	client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Assert(t, r.TLS != nil, "request must arrive over TLS")
+		fmt.Fprint(w, "secure")
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.CertFile, group.KeyFile = certFile, keyFile
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	WaitForURL(t, client, "https://servicegroup/")
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	err = <-done
+	Assert(t, strings.Contains(err.Error(), "interrupt"), "group must stop on the interrupt, got: %v", err)
+	summary := group.state.shutdowns
+	Equals(t, 1, len(summary))
+	Assert(t, summary[0].Graceful, "TLS server must shut down gracefully")
+}
+
+func TestNewWorkgroup_ServesDebugTLS(t *testing.T) {
+	// * Run a group whose debug server has a certificate and key from files
+	// * Validate pprof is served over HTTPS, and that both servers still shut down gracefully
+	dir, err := ioutil.TempDir("", "servicegroup")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	certPEM, keyPEM := selfSignedCert(t)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	Ok(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	Ok(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DebugServerAddr = "127.0.0.1:0"
+	group.DebugCertFile, group.DebugKeyFile = certFile, keyFile
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + group.DebugAddr() + "/debug/pprof/")
+	Ok(t, err)
+	resp.Body.Close()
+	Equals(t, http.StatusOK, resp.StatusCode)
+	Assert(t, resp.TLS != nil, "debug server must respond over TLS")
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+	for _, shutdown := range group.state.shutdowns {
+		Assert(t, shutdown.Graceful, "%s must shut down gracefully", shutdown.Name)
+	}
+	Equals(t, 2, len(group.state.shutdowns))
+}
+
+func TestNewWorkgroup_RequiresCertAndKeyTogether(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	group.CertFile = "cert.pem"
+	err := group.Run()
+	Assert(t, err != nil && strings.Contains(err.Error(), "KeyFile"), "Run must refuse a CertFile without a KeyFile, got: %v", err)
+}
+
+func TestNewWorkgroup_RegisterOnShutdownClosesHijackedConns(t *testing.T) {
+	// * Run a group whose handler hijacks its connections, like a WebSocket upgrade, and holds them open
+	// * Validate the func registered with RegisterOnShutdown closes them and the group stops promptly
+	hijacked := make(chan net.Conn, 1)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		Ok(t, err)
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+		hijacked <- conn
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.RegisterOnShutdown(func() {
+		(<-hijacked).Close()
+	})
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	Ok(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+	head := make([]byte, len("HTTP/1.1 101"))
+	_, err = io.ReadFull(conn, head)
+	Ok(t, err)
+	Equals(t, "HTTP/1.1 101", string(head))
+
+	start := time.Now()
+	group.Signal(syscall.SIGINT)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("group didn't stop promptly once the hijacked connection was closed")
+	}
+	Assert(t, time.Since(start) < 2*time.Second, "shutdown must not wait out the timeout, took %s", time.Since(start))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = ioutil.ReadAll(conn)
+	Ok(t, err) // EOF rather than a timeout: the registered func closed the connection
+}
+
+func TestNewWorkgroup_ReloadsHandlerOnSIGHUP(t *testing.T) {
+	// * Run a group with a HandlerReloader and send it SIGHUP
+	// * Validate subsequent requests are routed to the reloaded handler and the group keeps running
+	respond := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		})
+	}
+	listener, client := NewPipeListener()
+	group := NewGroup(respond("v1"))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.HandlerReloader = func() (http.Handler, error) { return respond("v2"), nil }
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	get := func() string {
+		resp, err := client.Get("http://servicegroup/")
+		Ok(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Ok(t, err)
+		return string(body)
+	}
+	Equals(t, "v1", get())
+
+	group.Signal(syscall.SIGHUP)
+	deadline := time.Now().Add(3 * time.Second)
+	for get() != "v2" {
+		Assert(t, time.Now().Before(deadline), "SIGHUP must swap in the reloaded handler")
+		time.Sleep(time.Millisecond)
+	}
+	Equals(t, phaseRunning, group.state.currentPhase(), "SIGHUP must not shut the group down")
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+}
+
+// selfSignedCert returns a PEM-encoded self-signed certificate and private key for localhost.
+func selfSignedCert(tb testing.TB) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ok(tb, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Ok(tb, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Ok(tb, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// WaitForURL polls url with a GET until it responds successfully, failing the test if it isn't up within 3 seconds.
+func WaitForURL(tb testing.TB, client *http.Client, url string) {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case <-timeout:
+			Assert(tb, false, "Timed out waiting for %s to become available.", url)
+			return
+		default:
+			resp, err := client.Get(url)
+			if err == nil {
+				resp.Body.Close()
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// AssertNoGoroutineLeak fails the test if the number of running goroutines doesn't settle back to at most before
+// within a couple of seconds.
+func AssertNoGoroutineLeak(tb testing.TB, before int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		buf := make([]byte, 1<<16)
+		buf = buf[:runtime.Stack(buf, true)]
+		Assert(tb, false, "leaked %d goroutines:\n%s", after-before, buf)
+	}
+}
+
 // Don't warn on unused helpers
-var _, _, _ = Assert, Ok, Equals
+var _, _, _, _, _ = Assert, Ok, Equals, WaitForURL, AssertNoGoroutineLeak
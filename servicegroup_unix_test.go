@@ -0,0 +1,54 @@
+//go:build !windows
+
+package servicegroup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestNewWorkgroup_ServesUnixSocket(t *testing.T) {
+	// * Run a group with the service server on a Unix socket
+	// * Validate requests are served over the socket, and that the socket file is gone after shutdown
+	dir, err := ioutil.TempDir("", "servicegroup")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "service.sock")
+
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	group.ServiceNetwork = "unix"
+	group.ServiceServerAddr = socket
+	group.DisableDebugServer = true
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+	resp, err := client.Get("http://servicegroup/ping")
+	Ok(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Ok(t, err)
+	Equals(t, "pong", string(body))
+
+	client.CloseIdleConnections()
+	group.Signal(syscall.SIGINT)
+	<-done
+	Assert(t, group.state.shutdowns[0].Graceful, "service server must shut down gracefully")
+	_, err = os.Stat(socket)
+	Assert(t, os.IsNotExist(err), "socket file must be removed on shutdown, got: %v", err)
+}
@@ -0,0 +1,93 @@
+package servicegroup
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// The OS signal hooks the Group's signal watchers subscribe through; replaceable in tests.
+var (
+	signalNotify = signal.Notify
+	signalStop   = signal.Stop
+)
+
+// signalSubscriptions fans signals pushed with Group.Signal out to the Group's signal watchers.
+type signalSubscriptions struct {
+	mu      sync.Mutex
+	subs    map[chan<- os.Signal][]os.Signal // subscribed channels and their signals; empty means all signals
+	pending []os.Signal                      // signals sent before anything subscribed to them
+}
+
+func newSignalSubscriptions() *signalSubscriptions {
+	return &signalSubscriptions{subs: make(map[chan<- os.Signal][]os.Signal)}
+}
+
+// notify relays the given OS signals (or all of them, if none are given) to c, like signal.Notify, as well as any
+// matching signals passed to Signal.
+func (g *Group) notify(c chan<- os.Signal, sigs ...os.Signal) {
+	s := g.state.signals
+	s.mu.Lock()
+	s.subs[c] = sigs
+	pending := s.pending[:0]
+	for _, sig := range s.pending {
+		if subscribed(sigs, sig) {
+			deliver(c, sig)
+		} else {
+			pending = append(pending, sig)
+		}
+	}
+	s.pending = pending
+	s.mu.Unlock()
+	signalNotify(c, sigs...)
+}
+
+// stopNotify undoes notify.
+func (g *Group) stopNotify(c chan<- os.Signal) {
+	signalStop(c)
+	s := g.state.signals
+	s.mu.Lock()
+	delete(s.subs, c)
+	s.mu.Unlock()
+}
+
+// Signal delivers sig to the Group's signal watchers as if the process had received it from the OS, without actually
+// signalling the process. If no watcher is subscribed to sig yet (eg Run hasn't started them), it's delivered once
+// one subscribes. This makes signal-driven behavior (like graceful shutdown on SIGTERM) straightforward and
+// deterministic to exercise in tests.
+func (g *Group) Signal(sig os.Signal) {
+	s := g.st().signals
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivered := false
+	for c, sigs := range s.subs {
+		if subscribed(sigs, sig) {
+			deliver(c, sig)
+			delivered = true
+		}
+	}
+	if !delivered {
+		s.pending = append(s.pending, sig)
+	}
+}
+
+// deliver sends sig on c without blocking, dropping it if c is full like the os/signal package does.
+func deliver(c chan<- os.Signal, sig os.Signal) {
+	select {
+	case c <- sig:
+	default:
+	}
+}
+
+// subscribed reports whether a subscription to sigs includes sig.
+func subscribed(sigs []os.Signal, sig os.Signal) bool {
+	if len(sigs) == 0 {
+		return true
+	}
+	for _, s := range sigs {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package servicegroup
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignal_DeliversToWatchers(t *testing.T) {
+	defer func(notify func(chan<- os.Signal, ...os.Signal), stop func(chan<- os.Signal)) {
+		signalNotify, signalStop = notify, stop
+	}(signalNotify, signalStop)
+	signalNotify = func(chan<- os.Signal, ...os.Signal) {}
+	signalStop = func(chan<- os.Signal) {}
+
+	group := NewGroup(nil)
+	group.Signal(syscall.SIGTERM) // before anything is watching
+
+	hangup := make(chan os.Signal, 1)
+	group.notify(hangup, syscall.SIGHUP)
+	terminate := make(chan os.Signal, 1)
+	group.notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+	Equals(t, syscall.SIGTERM, <-terminate, "signal sent before subscribing must be delivered on subscribe")
+
+	group.Signal(syscall.SIGHUP)
+	Equals(t, syscall.SIGHUP, <-hangup)
+	Equals(t, 0, len(terminate), "signals must only go to watchers subscribed to them")
+
+	group.stopNotify(hangup)
+	group.Signal(syscall.SIGHUP)
+	Equals(t, 0, len(hangup), "stopped watchers must not receive signals")
+}
+
+func TestNewWorkgroup_ShutsDownOnConfiguredSignals(t *testing.T) {
+	group := NewGroup(http.NotFoundHandler())
+	Equals(t, []os.Signal{syscall.SIGINT, syscall.SIGTERM}, group.ShutdownSignals)
+
+	listener, _ := NewPipeListener()
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ShutdownSignals = []os.Signal{syscall.SIGQUIT}
+	group.Signal(syscall.SIGQUIT)
+	err := group.Run()
+	Assert(t, err != nil && strings.Contains(err.Error(), "quit"), "Run must stop on SIGQUIT, got: %v", err)
+}
+
+func TestNewWorkgroup_ShutdownJitterKeepsServing(t *testing.T) {
+	// * Run a group with a ShutdownJitter, and send it an OS signal through the signal hook
+	// * Validate the service keeps serving during the jitter, and shutdown follows once it's over
+	notified := make(chan chan<- os.Signal, 1)
+	defer func(notify func(chan<- os.Signal, ...os.Signal), stop func(chan<- os.Signal), n func(int64) int64) {
+		signalNotify, signalStop, randInt63n = notify, stop, n
+	}(signalNotify, signalStop, randInt63n)
+	signalNotify = func(c chan<- os.Signal, sigs ...os.Signal) { notified <- c }
+	signalStop = func(chan<- os.Signal) {}
+	randInt63n = func(n int64) int64 { return n - 1 } // the longest jitter
+
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ShutdownJitter = 200 * time.Millisecond
+	group.ForceOnSecondSignal = false
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	signalled := time.Now()
+	(<-notified) <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+	resp, err := client.Get("http://pipe/")
+	Ok(t, err)
+	resp.Body.Close()
+	Equals(t, http.StatusOK, resp.StatusCode, "the service must keep serving during the jitter")
+	Equals(t, int32(0), atomic.LoadInt32(&group.state.shuttingDown), "shutdown must not begin during the jitter")
+
+	client.CloseIdleConnections()
+	<-done
+	Assert(t, time.Since(signalled) >= 200*time.Millisecond, "shutdown must wait out the jitter, took %s", time.Since(signalled))
+}
+
+func TestNewWorkgroup_SecondSignalCutsShutdownJitterShort(t *testing.T) {
+	// * Run a group with a long ShutdownJitter and a request that never finishes, then signal it twice
+	// * Validate the second signal starts a graceful shutdown right away rather than waiting out the jitter or forcing it
+	notified := make(chan chan<- os.Signal, 1)
+	defer func(notify func(chan<- os.Signal, ...os.Signal), stop func(chan<- os.Signal), n func(int64) int64) {
+		signalNotify, signalStop, randInt63n = notify, stop, n
+	}(signalNotify, signalStop, randInt63n)
+	signalNotify = func(c chan<- os.Signal, sigs ...os.Signal) { notified <- c }
+	signalStop = func(chan<- os.Signal) {}
+	randInt63n = func(n int64) int64 { return n - 1 }
+
+	release := make(chan struct{})
+	listener, client := NewPipeListener()
+	group := NewGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.ShutdownJitter = time.Minute
+	var graceful []bool
+	group.OnShutdownComplete = func(name string, elapsed time.Duration, ok bool) {
+		graceful = append(graceful, ok)
+	}
+	done := make(chan error, 1)
+	go func() { done <- group.Run() }()
+	<-group.Ready()
+
+	go client.Get("http://pipe/")
+	for group.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	interrupt := <-notified
+	interrupt <- syscall.SIGTERM
+	interrupt <- syscall.SIGTERM // blocks until the watcher has taken the first
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(&group.state.shuttingDown) == 0 {
+		Assert(t, time.Now().Before(deadline), "a second signal during the jitter must begin shutdown right away")
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("the second signal must not force the shutdown; the request is still draining")
+	default:
+	}
+
+	close(release)
+	<-done
+	Equals(t, []bool{true}, graceful)
+}
@@ -0,0 +1,21 @@
+package servicegroup
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logEvent emits a lifecycle event with attrs to the SlogHandler at info level; without a SlogHandler the text
+// message is written to the Logger as before.
+func (g *Group) logEvent(event string, attrs []slog.Attr, format string, args ...interface{}) {
+	g.logEventLevel(slog.LevelInfo, event, attrs, format, args...)
+}
+
+// logEventLevel is logEvent at the given level.
+func (g *Group) logEventLevel(level slog.Level, event string, attrs []slog.Attr, format string, args ...interface{}) {
+	if g.SlogHandler == nil {
+		g.logf(format, args...)
+		return
+	}
+	slog.New(g.SlogHandler).LogAttrs(context.Background(), level, event, attrs...)
+}
@@ -0,0 +1,56 @@
+package servicegroup
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestNewWorkgroup_EmitsSlogEvents(t *testing.T) {
+	// * Run a group with a JSON slog handler and interrupt it
+	// * Validate the lifecycle events are emitted with their attributes, including the shutdown's elapsed time
+	var out syncBuffer
+	listener, _ := NewPipeListener()
+	group := NewGroup(http.NotFoundHandler())
+	group.ServiceListener = listener
+	group.DisableDebugServer = true
+	group.SlogHandler = slog.NewJSONHandler(&out, nil)
+	group.Signal(syscall.SIGINT)
+	_ = group.Run()
+
+	events := make(map[string]map[string]interface{})
+	for _, line := range bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		Ok(t, json.Unmarshal(line, &record))
+		events[record["msg"].(string)] = record
+	}
+	Equals(t, "pipe", events["server.starting"]["addr"])
+	Equals(t, "interrupt", events["signal.received"]["signal"])
+	shutdown, ok := events["server.shutdown.graceful"]
+	Assert(t, ok, "graceful shutdown event must be emitted, got: %v", events)
+	Equals(t, "service HTTP server", shutdown["server"])
+	_, ok = shutdown["elapsed"].(float64)
+	Assert(t, ok, "shutdown event must include the elapsed duration, got: %v", shutdown)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
@@ -0,0 +1,60 @@
+package servicegroup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ShutdownSummary describes how a Group shut down, once Run has finished draining everything.
+type ShutdownSummary struct {
+	Reason   error            // the error that triggered shutdown, also returned by Run
+	Duration time.Duration    // from the first server starting to shut down until all workers finished
+	Servers  []ServerShutdown // outcome of each HTTP server's shutdown, in the order they finished
+}
+
+// ServerShutdown is the outcome of shutting down one of the Group's HTTP servers.
+type ServerShutdown struct {
+	Name        string        // eg "service HTTP server"
+	Graceful    bool          // false if the server had to be forcibly closed
+	Elapsed     time.Duration // how long the shutdown took
+	ForceClosed int           // connections still open when the server was forcibly closed
+}
+
+// String formats the summary as a single log line.
+func (s ShutdownSummary) String() string {
+	servers := make([]string, len(s.Servers))
+	for i, server := range s.Servers {
+		outcome := "graceful"
+		if !server.Graceful {
+			outcome = fmt.Sprintf("hard, %d connections force-closed", server.ForceClosed)
+		}
+		servers[i] = fmt.Sprintf("%s %s in %s", server.Name, outcome, server.Elapsed)
+	}
+	return fmt.Sprintf("Shutdown complete in %s (reason: %v): %s", s.Duration, s.Reason, strings.Join(servers, "; "))
+}
+
+// recordServerShutdown adds a server's shutdown outcome to the summary of the Group's current shutdown.
+func (g *Group) recordServerShutdown(server ServerShutdown) {
+	g.state.mu.Lock()
+	defer g.state.mu.Unlock()
+	g.state.shutdowns = append(g.state.shutdowns, server)
+}
+
+// summarizeShutdown logs the summary of the Group's shutdown, and passes it to OnShutdownSummary if set.
+func (g *Group) summarizeShutdown(reason error) {
+	g.state.mu.Lock()
+	summary := ShutdownSummary{
+		Reason:  reason,
+		Servers: append([]ServerShutdown(nil), g.state.shutdowns...),
+	}
+	if !g.state.shutdownStarted.IsZero() {
+		summary.Duration = time.Since(g.state.shutdownStarted)
+	}
+	g.state.mu.Unlock()
+
+	g.logf("%s", summary)
+	if g.OnShutdownSummary != nil {
+		g.OnShutdownSummary(summary)
+	}
+}
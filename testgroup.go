@@ -0,0 +1,28 @@
+package servicegroup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// NewTestGroup starts a Group serving handler for integration tests, like httptest.NewServer: both servers listen on
+// ports picked on 127.0.0.1 and the signal watcher is disabled, so tests can run in parallel without touching the
+// process's signals. opts are applied on top of those defaults. It returns once the listeners are bound, so
+// ServiceURL and DebugAddr are ready to use, and panics if binding them fails. cleanup shuts the Group down
+// gracefully and waits for Run to return.
+func NewTestGroup(handler http.Handler, opts ...Option) (group *Group, cleanup func()) {
+	defaults := []Option{WithServiceAddr("127.0.0.1:0"), WithDebugAddr("127.0.0.1:0"), WithSignalWatcherDisabled()}
+	g := NewGroup(handler, append(defaults, opts...)...)
+	done := make(chan error, 1)
+	go func() { done <- g.Run() }()
+	select {
+	case <-g.Ready():
+	case err := <-done:
+		panic(fmt.Sprintf("servicegroup: failed to start test group: %v", err))
+	}
+	return &g, func() {
+		g.Shutdown(context.Background())
+		<-done
+	}
+}
@@ -0,0 +1,38 @@
+package servicegroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTestGroup(t *testing.T) {
+	// * Start a test group and request its service URL and debug server
+	// * Validate cleanup shuts it down, freeing the port
+	group, cleanup := NewTestGroup(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}), WithShutdownTimeout(time.Second))
+	Equals(t, time.Second, group.ShutdownTimeout, "options must apply on top of the test defaults")
+	url := group.ServiceURL()
+	Assert(t, strings.HasPrefix(url, "http://127.0.0.1:") && !strings.HasSuffix(url, ":0"), "service URL must name the bound port, got %q", url)
+
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Get(url)
+	Ok(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	Equals(t, "hello", string(body))
+	resp, err = client.Get("http://" + group.DebugAddr() + "/readyz")
+	Ok(t, err)
+	resp.Body.Close()
+	Equals(t, http.StatusOK, resp.StatusCode)
+
+	client.CloseIdleConnections()
+	cleanup()
+	Equals(t, phaseStopped, group.state.currentPhase())
+	_, err = client.Get(url)
+	Assert(t, err != nil, "service server must be closed after cleanup")
+}